@@ -15,8 +15,10 @@ import (
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/middleware"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/notifier"
 	promPkg "github.com/ahmadnaufal/openidea-paimonbank/pkg/prometheus"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/s3"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/storage"
 	"github.com/dlmiddlecote/sqlstats"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -25,7 +27,6 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
@@ -43,9 +44,12 @@ func main() {
 	// custom middleware to set all method not allowed response to not found
 	app.Use(middleware.CustomMiddleware404())
 
-	jwtProvider := jwt.NewJWTProvider(cfg.JWTSecret)
+	jwtProvider, err := newJWTProvider(cfg)
+	if err != nil {
+		panic(err)
+	}
 
-	db := connectToDB(cfg.Database)
+	db := config.ConnectToDB(cfg.Database)
 	dbCollector := sqlstats.NewStatsCollector("paimonbank", db)
 	prometheus.MustRegister(dbCollector)
 
@@ -55,27 +59,124 @@ func main() {
 	app.Use(prom.Middleware())
 
 	userRepo := user.NewUserRepo(db)
+	// tokens are only accepted while their session hasn't been revoked
+	jwtProvider.SetSessionValidator(&userRepo)
 	balanceRepo := balance.NewBalanceRepo(db)
+	imageRepo := image.NewImageRepo(db)
+
+	trxProvider := config.NewTransactionProvider(db)
+
+	// background jobs are stopped together with the app
+	bgCtx, stopBackgroundJobs := context.WithCancel(context.Background())
+	defer stopBackgroundJobs()
+
+	idempotencyStore := middleware.NewPostgresIdempotencyStore(db)
+	idempotencyStore.StartCleanup(bgCtx, time.Hour)
+	idempotencyMiddleware := middleware.Idempotency(middleware.IdempotencyConfig{
+		Store: &idempotencyStore,
+		TTL:   cfg.IdempotencyKeyTTL,
+		Scope: func(c *fiber.Ctx) (string, error) {
+			claims, err := jwt.GetLoggedInUser(c)
+			return claims.UserID, err
+		},
+	})
+
+	rateProvider, err := balance.NewFileRateProvider(cfg.ExchangeRatesFile)
+	if err != nil {
+		panic(err)
+	}
 
-	// trxProvider := config.NewTransactionProvider(db)
+	stepUpThresholds, err := balance.ParseStepUpThresholds(cfg.StepUpThresholds)
+	if err != nil {
+		panic(err)
+	}
 
-	awsCfg, err := awsConfig.LoadDefaultConfig(context.TODO())
+	limitRules, err := balance.NewFileLimitRules(cfg.LimitsFile)
 	if err != nil {
 		panic(err)
 	}
 
-	s3Provider := s3.NewS3Provider(awsCfg, cfg.S3.Bucket, cfg.S3.Region, cfg.S3.ID, cfg.S3.SecretKey)
+	var objectStore storage.ObjectStore
+	if cfg.S3Enabled {
+		awsCfg, err := awsConfig.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			panic(err)
+		}
+
+		s3Provider := s3.NewS3Provider(awsCfg, cfg.S3.Bucket, cfg.S3.Region, cfg.S3.ID, cfg.S3.SecretKey)
+		objectStore = &s3Provider
+	} else {
+		localStore, err := storage.NewLocalStore(cfg.LocalStorage.Dir, cfg.LocalStorage.BaseURL, cfg.LocalStorage.SigningKey)
+		if err != nil {
+			panic(err)
+		}
+
+		localStore.RegisterRoute(app)
+		objectStore = &localStore
+	}
+
+	var userNotifier notifier.Notifier
+	if cfg.NotifierFile != "" {
+		userNotifier = notifier.NewFileNotifier(cfg.NotifierFile)
+	} else {
+		logNotifier := notifier.NewLogNotifier()
+		userNotifier = &logNotifier
+	}
+
+	totpVerifier := user.NewTOTPVerifier(&userRepo)
+
+	loginAttemptStore := user.NewPostgresLoginAttemptStore(db)
+	loginAttemptStore.StartCleanup(bgCtx, time.Hour, cfg.LoginThrottle.FailureWindow)
+	auditLogger := user.NewPostgresAuditLogger(db)
+	loginThrottler := user.NewLoginThrottler(user.LoginThrottlerConfig{
+		Store:              &loginAttemptStore,
+		AuditLogger:        &auditLogger,
+		MaxAccountFailures: cfg.LoginThrottle.MaxAccountFailures,
+		MaxIPFailures:      cfg.LoginThrottle.MaxIPFailures,
+		BaseDelay:          cfg.LoginThrottle.BaseDelay,
+		FailureWindow:      cfg.LoginThrottle.FailureWindow,
+		LockoutDuration:    cfg.LoginThrottle.LockoutDuration,
+	})
 
-	imageHandler := image.NewImageHandler(&s3Provider)
 	userHandler := user.NewUserHandler(user.UserHandlerConfig{
-		UserRepo:    &userRepo,
-		JwtProvider: &jwtProvider,
-		SaltCost:    cfg.BcryptSalt,
+		UserRepo:                  &userRepo,
+		JwtProvider:               &jwtProvider,
+		TrxProvider:               &trxProvider,
+		LoginThrottler:            &loginThrottler,
+		TOTPVerifier:              &totpVerifier,
+		Notifier:                  userNotifier,
+		AuditLogger:               &auditLogger,
+		SaltCost:                  cfg.BcryptSalt,
+		AccessTokenTTL:            cfg.AccessTokenTTL,
+		RefreshTokenTTL:           cfg.RefreshTokenTTL,
+		BalanceChecker:            &balanceRepo,
+		PasswordResetTokenTTL:     cfg.PasswordResetTokenTTL,
+		EmailVerificationTokenTTL: cfg.EmailVerificationTokenTTL,
+		MFAChallengeTTL:           cfg.MFAChallengeTTL,
 	})
 	balanceHandler := balance.NewBalance(balance.BalanceHandlerConfig{
-		BalanceRepo: &balanceRepo,
+		BalanceRepo:           &balanceRepo,
+		UserRepo:              &userRepo,
+		ImageRepo:             &imageRepo,
+		TrxProvider:           &trxProvider,
+		IdempotencyMiddleware: idempotencyMiddleware,
+		AuditLogger:           &auditLogger,
+		RateProvider:          &rateProvider,
+		QuoteTTL:              cfg.ExchangeQuoteTTL,
+		TOTPVerifier:          &totpVerifier,
+		StepUpThrottler:       &loginThrottler,
+		StepUpThresholds:      stepUpThresholds,
+		LimitRules:            limitRules,
 	})
 
+	imageHandler := image.NewImageHandler(image.ImageHandlerConfig{
+		ObjectStore:  objectStore,
+		ImageRepo:    &imageRepo,
+		AuditLogger:  &auditLogger,
+		SignedURLTTL: cfg.ImageURLTTL,
+	})
+
+	app.Get("/.well-known/jwks.json", jwtProvider.JWKSHandler())
 	imageHandler.RegisterRoute(app, jwtProvider)
 	userHandler.RegisterRoute(app, jwtProvider)
 	balanceHandler.RegisterRoute(app, jwtProvider)
@@ -96,6 +197,7 @@ func main() {
 	receivedSignal := <-sig
 
 	log.Printf("received %v. Stopping app...", receivedSignal)
+	stopBackgroundJobs()
 	if err := app.Shutdown(); err != nil {
 		log.Println("failed to shutdown server: ", err)
 		os.Exit(1)
@@ -104,27 +206,21 @@ func main() {
 	log.Println("App successfully stopped.")
 }
 
-func connectToDB(dbCfg config.DatabaseConfig) *sqlx.DB {
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?%s",
-		dbCfg.Username, dbCfg.Password, dbCfg.Host,
-		dbCfg.Port, dbCfg.Name, dbCfg.Params,
-	)
+// newJWTProvider uses the keys file if it's set, otherwise it falls back to the HS256 secret
+func newJWTProvider(cfg config.Config) (jwt.JWTProvider, error) {
+	if cfg.JWTKeysFile != "" {
+		activeKeyID, keys, err := jwt.LoadKeyFile(cfg.JWTKeysFile)
+		if err != nil {
+			return jwt.JWTProvider{}, err
+		}
 
-	db, err := sqlx.Open("postgres", dsn)
-	if err != nil {
-		panic(err)
+		return jwt.NewJWTProvider(activeKeyID, keys...)
 	}
 
-	db.SetMaxOpenConns(dbCfg.MaxOpenConnection)
-	db.SetMaxIdleConns(dbCfg.MaxIdleConnection)
-	db.SetConnMaxLifetime(time.Duration(dbCfg.MaxConnLifetime) * time.Minute)
-	db.SetConnMaxIdleTime(time.Duration(dbCfg.MaxConnIdleTime) * time.Minute)
-
-	err = db.Ping()
+	key, err := jwt.NewHMACKey("", cfg.JWTSecret)
 	if err != nil {
-		panic(err)
+		return jwt.JWTProvider{}, err
 	}
 
-	return db
+	return jwt.NewJWTProvider("", key)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/balance"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	_ "github.com/lib/pq"
+)
+
+// reconcile verifies that every journal entry in the ledger is balanced, and that the materialized
+// user balances match the sum of the ledger postings. It exits with a non-zero code if anything differs.
+func main() {
+	cfg := config.InitializeConfig()
+
+	db := config.ConnectToDB(cfg.Database)
+	defer db.Close()
+
+	balanceRepo := balance.NewBalanceRepo(db)
+	ctx := context.Background()
+
+	unbalancedEntries, err := balanceRepo.GetUnbalancedJournalEntries(ctx)
+	if err != nil {
+		log.Println("failed to check journal entries: ", err)
+		os.Exit(1)
+	}
+
+	for _, e := range unbalancedEntries {
+		log.Printf(
+			"unbalanced journal entry: entry %s currency %s postings sum to %d",
+			e.JournalEntryID, e.Currency, e.TotalAmount,
+		)
+	}
+
+	mismatches, err := balanceRepo.GetBalanceMismatches(ctx)
+	if err != nil {
+		log.Println("failed to reconcile balances: ", err)
+		os.Exit(1)
+	}
+
+	for _, m := range mismatches {
+		log.Printf(
+			"balance mismatch: user %s currency %s has balance %d, but ledger postings sum to %d",
+			m.UserID, m.Currency, m.BalanceAmount, m.LedgerAmount,
+		)
+	}
+
+	if len(unbalancedEntries) > 0 || len(mismatches) > 0 {
+		log.Printf("found %d unbalanced journal entries and %d balance mismatches", len(unbalancedEntries), len(mismatches))
+		os.Exit(1)
+	}
+
+	log.Println("The ledger is balanced and all balances match it.")
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS user_balances (
+  user_id VARCHAR(48) NOT NULL,
+  currency VARCHAR(6) NOT NULL,
+  amount INTEGER NOT NULL DEFAULT 0,
+  version INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  updated_at TIMESTAMP(0) DEFAULT NOW(),
+  PRIMARY KEY (user_id, currency)
+);
+
+-- backfill the materialized balances from the existing histories
+INSERT INTO user_balances (user_id, currency, amount, version)
+SELECT
+  user_id,
+  currency,
+  SUM(balance),
+  COUNT(*)
+FROM
+  balance_histories
+GROUP BY
+  user_id, currency
+ON CONFLICT (user_id, currency) DO NOTHING;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS balance_histories_transfer_reference_idx;
+
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS transfer_reference;
+ALTER TABLE balance_histories ALTER COLUMN source_bank_account_number TYPE VARCHAR(32);
@@ -0,0 +1,5 @@
+-- internal transfers store the counterparty user ID as the account number
+ALTER TABLE balance_histories ALTER COLUMN source_bank_account_number TYPE VARCHAR(48);
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS transfer_reference VARCHAR(48);
+
+CREATE INDEX IF NOT EXISTS balance_histories_transfer_reference_idx ON balance_histories (transfer_reference);
@@ -0,0 +1,3 @@
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS exchange_rate;
+
+DROP TABLE IF EXISTS exchange_quotes;
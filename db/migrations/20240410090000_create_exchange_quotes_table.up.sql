@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS exchange_quotes (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL,
+  from_currency VARCHAR(6) NOT NULL,
+  to_currency VARCHAR(6) NOT NULL,
+  rate NUMERIC(24, 12) NOT NULL,
+  from_amount INTEGER NOT NULL,
+  to_amount INTEGER NOT NULL,
+  expires_at TIMESTAMP(0) NOT NULL,
+  executed_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  updated_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS exchange_quotes_user_id_idx ON exchange_quotes (user_id);
+
+-- both legs of an exchange record the rate they were converted with
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(24, 12);
@@ -0,0 +1,29 @@
+-- amounts are now stored in the minor unit of their currency, e.g. cents for USD.
+-- currencies not listed here have 2 minor unit digits
+CREATE TEMPORARY TABLE currency_exponents (
+  currency VARCHAR(6) PRIMARY KEY,
+  exponent INTEGER NOT NULL
+);
+
+INSERT INTO currency_exponents (currency, exponent) VALUES
+  ('BIF', 0), ('CLP', 0), ('DJF', 0), ('GNF', 0), ('ISK', 0), ('JPY', 0), ('KMF', 0), ('KRW', 0),
+  ('PYG', 0), ('RWF', 0), ('UGX', 0), ('UYI', 0), ('VND', 0), ('VUV', 0), ('XAF', 0), ('XOF', 0), ('XPF', 0),
+  ('BHD', 3), ('IQD', 3), ('JOD', 3), ('KWD', 3), ('LYD', 3), ('OMR', 3), ('TND', 3),
+  ('CLF', 4), ('UYW', 4);
+
+ALTER TABLE balance_histories ALTER COLUMN balance TYPE BIGINT;
+UPDATE balance_histories bh
+SET balance = bh.balance * (10 ^ COALESCE((SELECT ce.exponent FROM currency_exponents ce WHERE ce.currency = bh.currency), 2))::BIGINT;
+
+ALTER TABLE user_balances ALTER COLUMN amount TYPE BIGINT;
+UPDATE user_balances ub
+SET amount = ub.amount * (10 ^ COALESCE((SELECT ce.exponent FROM currency_exponents ce WHERE ce.currency = ub.currency), 2))::BIGINT;
+
+ALTER TABLE exchange_quotes ALTER COLUMN from_amount TYPE BIGINT;
+ALTER TABLE exchange_quotes ALTER COLUMN to_amount TYPE BIGINT;
+UPDATE exchange_quotes eq
+SET
+  from_amount = eq.from_amount * (10 ^ COALESCE((SELECT ce.exponent FROM currency_exponents ce WHERE ce.currency = eq.from_currency), 2))::BIGINT,
+  to_amount = eq.to_amount * (10 ^ COALESCE((SELECT ce.exponent FROM currency_exponents ce WHERE ce.currency = eq.to_currency), 2))::BIGINT;
+
+DROP TABLE currency_exponents;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS balance_histories_journal_entry_id_idx;
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS journal_entry_id;
+
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
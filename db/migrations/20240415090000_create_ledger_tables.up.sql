@@ -0,0 +1,92 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+  id VARCHAR(64) PRIMARY KEY,
+  user_id VARCHAR(48),
+  kind VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_user_id_idx ON ledger_accounts (user_id);
+
+-- system accounts are the other side of money entering, leaving, or changing currency in the bank
+INSERT INTO ledger_accounts (id, kind) VALUES
+  ('system:external-bank-inflow', 'system'),
+  ('system:external-bank-outflow', 'system'),
+  ('system:currency-exchange', 'system')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+  id VARCHAR(48) PRIMARY KEY,
+  kind VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+  id BIGSERIAL PRIMARY KEY,
+  journal_entry_id VARCHAR(48) NOT NULL REFERENCES journal_entries (id),
+  account_id VARCHAR(64) NOT NULL REFERENCES ledger_accounts (id),
+  currency VARCHAR(6) NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS postings_journal_entry_id_idx ON postings (journal_entry_id);
+CREATE INDEX IF NOT EXISTS postings_account_id_currency_idx ON postings (account_id, currency);
+
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS journal_entry_id VARCHAR(48) REFERENCES journal_entries (id);
+
+CREATE INDEX IF NOT EXISTS balance_histories_journal_entry_id_idx ON balance_histories (journal_entry_id);
+
+-- backfill the ledger from the existing histories. Both sides of a transfer or an exchange share one entry
+INSERT INTO ledger_accounts (id, user_id, kind)
+SELECT DISTINCT
+  'user:' || user_id,
+  user_id,
+  'customer'
+FROM
+  balance_histories
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO journal_entries (id, kind, created_at)
+SELECT DISTINCT ON (COALESCE(transfer_reference, id))
+  COALESCE(transfer_reference, id),
+  CASE
+    WHEN exchange_rate IS NOT NULL THEN 'exchange'
+    WHEN transfer_reference IS NOT NULL THEN 'transfer'
+    WHEN balance >= 0 THEN 'topup'
+    ELSE 'withdrawal'
+  END,
+  created_at
+FROM
+  balance_histories
+ORDER BY
+  COALESCE(transfer_reference, id), created_at
+ON CONFLICT (id) DO NOTHING;
+
+UPDATE balance_histories SET journal_entry_id = COALESCE(transfer_reference, id);
+
+INSERT INTO postings (journal_entry_id, account_id, currency, amount, created_at)
+SELECT
+  journal_entry_id,
+  'user:' || user_id,
+  currency,
+  balance,
+  created_at
+FROM
+  balance_histories;
+
+INSERT INTO postings (journal_entry_id, account_id, currency, amount, created_at)
+SELECT
+  bh.journal_entry_id,
+  CASE je.kind
+    WHEN 'topup' THEN 'system:external-bank-inflow'
+    WHEN 'withdrawal' THEN 'system:external-bank-outflow'
+    ELSE 'system:currency-exchange'
+  END,
+  bh.currency,
+  -bh.balance,
+  bh.created_at
+FROM
+  balance_histories bh
+  JOIN journal_entries je ON je.id = bh.journal_entry_id
+WHERE
+  je.kind IN ('topup', 'withdrawal', 'exchange');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS balance_histories_reversal_of_idx;
+DROP INDEX IF EXISTS journal_entries_reversal_of_idx;
+
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS reversal_of;
+ALTER TABLE journal_entries DROP COLUMN IF EXISTS created_by;
+ALTER TABLE journal_entries DROP COLUMN IF EXISTS reversal_of;
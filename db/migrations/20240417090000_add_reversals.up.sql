@@ -0,0 +1,8 @@
+ALTER TABLE journal_entries ADD COLUMN IF NOT EXISTS reversal_of VARCHAR(48) REFERENCES journal_entries (id);
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS reversal_of VARCHAR(48);
+-- the operator who posted the entry by hand, e.g. a reversal. Entries posted by the users themselves have none.
+ALTER TABLE journal_entries ADD COLUMN IF NOT EXISTS created_by VARCHAR(48);
+
+-- an entry or a history can only be reversed once
+CREATE UNIQUE INDEX IF NOT EXISTS journal_entries_reversal_of_idx ON journal_entries (reversal_of) WHERE reversal_of IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS balance_histories_reversal_of_idx ON balance_histories (reversal_of) WHERE reversal_of IS NOT NULL;
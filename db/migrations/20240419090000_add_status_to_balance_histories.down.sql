@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS balance_histories_status_idx;
+
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS reviewed_by;
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS reviewed_at;
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE balance_histories DROP COLUMN IF EXISTS status;
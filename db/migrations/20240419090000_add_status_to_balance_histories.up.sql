@@ -0,0 +1,13 @@
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'COMPLETED';
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS status_reason VARCHAR(256);
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP(0);
+-- the operator who approved or rejected the top-up
+ALTER TABLE balance_histories ADD COLUMN IF NOT EXISTS reviewed_by VARCHAR(48);
+
+-- top-ups made before the verification workflow were credited right away
+UPDATE balance_histories bh
+SET status = 'APPROVED'
+FROM journal_entries je
+WHERE je.id = bh.journal_entry_id AND je.kind = 'topup';
+
+CREATE INDEX IF NOT EXISTS balance_histories_status_idx ON balance_histories (status);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS images (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL,
+  object_key VARCHAR(128) NOT NULL,
+  url VARCHAR(128) NOT NULL UNIQUE,
+  size BIGINT NOT NULL,
+  content_hash VARCHAR(64) NOT NULL,
+  -- the top-up which used the image as its transfer proof
+  used_by VARCHAR(48) UNIQUE REFERENCES balance_histories (id),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  updated_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS images_user_id_idx ON images (user_id);
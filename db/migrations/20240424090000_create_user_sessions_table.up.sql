@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL REFERENCES users (id),
+  revoked_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  updated_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id);
+
+-- refresh tokens are only stored hashed, and rotated every time they're used
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id VARCHAR(48) PRIMARY KEY,
+  session_id VARCHAR(48) NOT NULL REFERENCES user_sessions (id),
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_id_idx ON refresh_tokens (session_id);
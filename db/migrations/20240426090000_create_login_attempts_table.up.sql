@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+  -- either account:<email> or ip:<address>
+  attempt_key VARCHAR(128) PRIMARY KEY,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMP(0) NOT NULL,
+  locked_until TIMESTAMP(0)
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failed_at_idx ON login_attempts (last_failed_at);
+
+CREATE TABLE IF NOT EXISTS auth_audit_logs (
+  id VARCHAR(48) PRIMARY KEY,
+  event VARCHAR(32) NOT NULL,
+  subject VARCHAR(128) NOT NULL,
+  ip_address VARCHAR(64),
+  detail TEXT,
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS auth_audit_logs_subject_idx ON auth_audit_logs (subject);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id VARCHAR(48) PRIMARY KEY REFERENCES users (id),
+  secret VARCHAR(64) NOT NULL,
+  -- the enrollment is pending until the user confirms it with a code
+  enabled_at TIMESTAMP(0),
+  -- every code is only accepted once
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP(0) DEFAULT NOW(),
+  updated_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL REFERENCES users (id),
+  code_hash VARCHAR(64) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
+
+-- a login whose password is verified, waiting for the second factor
+CREATE TABLE IF NOT EXISTS login_challenges (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL REFERENCES users (id),
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
@@ -0,0 +1,15 @@
+-- closed accounts are kept for the records, but can't be used anymore
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0);
+
+-- an email only replaces the user's one after it's verified through the token sent to it
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL REFERENCES users (id),
+  email VARCHAR(64) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMP(0) NOT NULL,
+  used_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS email_verification_tokens_user_id_idx ON email_verification_tokens (user_id);
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP(0);
+
+-- the users registered before the verification existed keep moving money as they did
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS users_name_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS frozen_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS frozen_at;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
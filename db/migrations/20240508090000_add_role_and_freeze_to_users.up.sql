@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'customer';
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('customer', 'support', 'admin'));
+
+-- a frozen account can still log in and view its balances, but can't move money
+ALTER TABLE users ADD COLUMN IF NOT EXISTS frozen_at TIMESTAMP(0);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS frozen_reason VARCHAR(256);
+
+CREATE INDEX IF NOT EXISTS users_name_idx ON users (name);
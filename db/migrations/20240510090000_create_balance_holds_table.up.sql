@@ -0,0 +1,14 @@
+-- a hold reserves part of a balance, so it can't be moved, without posting a debit
+CREATE TABLE IF NOT EXISTS balance_holds (
+  id VARCHAR(48) PRIMARY KEY,
+  user_id VARCHAR(48) NOT NULL REFERENCES users (id),
+  currency VARCHAR(6) NOT NULL,
+  amount BIGINT NOT NULL CHECK (amount > 0),
+  reason VARCHAR(256) NOT NULL,
+  created_by VARCHAR(48) NOT NULL,
+  released_by VARCHAR(48),
+  released_at TIMESTAMP(0),
+  created_at TIMESTAMP(0) DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS balance_holds_active_idx ON balance_holds (user_id, currency) WHERE released_at IS NULL;
@@ -0,0 +1,2 @@
+-- the tier decides which transaction limits apply to the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier VARCHAR(16) NOT NULL DEFAULT 'standard';
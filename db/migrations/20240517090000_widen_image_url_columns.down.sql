@@ -0,0 +1,2 @@
+ALTER TABLE balance_histories ALTER COLUMN transfer_proof_img_url TYPE VARCHAR(128);
+ALTER TABLE images ALTER COLUMN url TYPE VARCHAR(128);
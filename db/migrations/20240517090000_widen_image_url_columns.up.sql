@@ -0,0 +1,3 @@
+-- signed and presigned object URLs don't fit in 128 characters
+ALTER TABLE images ALTER COLUMN url TYPE TEXT;
+ALTER TABLE balance_histories ALTER COLUMN transfer_proof_img_url TYPE TEXT;
@@ -0,0 +1,37 @@
+package balance
+
+import (
+	"database/sql"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// requireActiveAccount refuses the money-moving requests of users who haven't verified their email yet,
+// or whose account has been frozen
+func (h *balanceHandler) requireActiveAccount(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	// the flags are read from the database, so it's effective without waiting for a new token
+	user, err := h.userRepo.GetUserByID(c.Context(), claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrRequestForbidden
+		}
+
+		return errors.Wrap(err, "GetUserByID error")
+	}
+	if !user.EmailVerified() {
+		return config.ErrEmailNotVerified
+	}
+	if user.Frozen() {
+		return config.ErrAccountFrozen
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,58 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// GetUserBalances returns the balances of any user. It's only available for support staff and admins.
+func (h *balanceHandler) GetUserBalances(c *fiber.Ctx) error {
+	var payload AdminUserRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return h.respondBalances(c, payload.UserID)
+}
+
+// GetUserBalanceHistory returns the balance history of any user, with the same filters the user has.
+// It's only available for support staff and admins.
+func (h *balanceHandler) GetUserBalanceHistory(c *fiber.Ctx) error {
+	var payload AdminUserRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return h.respondBalanceHistory(c, payload.UserID)
+}
+
+// logStaffAction records what the staff member did to the user's money in tx, like the admin API does,
+// so the log is committed or rolled back together with the action
+func (h *balanceHandler) logStaffAction(ctx context.Context, tx *sql.Tx, staffID, ip, event, userID, detail string) error {
+	err := h.auditLogger.LogTx(ctx, tx, user.AuditLog{
+		Event:     event,
+		Subject:   userID,
+		IPAddress: ip,
+		Detail:    fmt.Sprintf("by %s: %s", staffID, detail),
+	})
+	if err != nil {
+		return errors.Wrap(err, "audit log error")
+	}
+
+	return nil
+}
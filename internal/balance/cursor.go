@@ -0,0 +1,46 @@
+package balance
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// historyCursor points to a balance history row, for keyset pagination over (created_at, id)
+type historyCursor struct {
+	CreatedAt time.Time `json:"createdAt"`
+	ID        string    `json:"id"`
+
+	// Backward is set when the cursor fetches the page before the row, instead of after it
+	Backward bool `json:"backward,omitempty"`
+}
+
+func newHistoryCursor(val BalanceHistory, backward bool) historyCursor {
+	return historyCursor{
+		CreatedAt: val.CreatedAt,
+		ID:        val.ID,
+		Backward:  backward,
+	}
+}
+
+func (c historyCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	var cursor historyCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, errors.New("cursor is invalid")
+	}
+
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID == "" {
+		return cursor, errors.New("cursor is invalid")
+	}
+
+	return cursor, nil
+}
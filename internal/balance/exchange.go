@@ -0,0 +1,237 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// rates are stored with the same precision as the exchange_rate columns
+const ratePrecision = 12
+
+func (h *balanceHandler) CreateExchangeQuote(c *fiber.Ctx) error {
+	var payload CreateExchangeQuoteRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	amount, err := parseAmount(payload.Amount, payload.FromCurrency)
+	if err != nil {
+		return err
+	}
+
+	quote, err := h.createExchangeQuote(c.Context(), payload, amount)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data: ExchangeQuoteResponse{
+			QuoteID:      quote.ID,
+			FromCurrency: quote.FromCurrency,
+			ToCurrency:   quote.ToCurrency,
+			Rate:         quote.Rate,
+			FromAmount:   money.New(quote.FromAmount, quote.FromCurrency).Decimal(),
+			ToAmount:     money.New(quote.ToAmount, quote.ToCurrency).Decimal(),
+			ExpiresAt:    uint64(quote.ExpiresAt.UnixMilli()),
+		},
+	})
+}
+
+func (h *balanceHandler) createExchangeQuote(ctx context.Context, payload CreateExchangeQuoteRequest, amount money.Money) (ExchangeQuote, error) {
+	fromCurrency := amount.Currency
+	toCurrency := strings.ToUpper(payload.ToCurrency)
+
+	rate, err := h.rateProvider.GetRate(ctx, fromCurrency, toCurrency)
+	if err != nil {
+		if err == ErrRateNotFound {
+			return ExchangeQuote{}, config.ErrExchangeRateNotFound
+		}
+
+		return ExchangeQuote{}, errors.Wrap(err, "GetRate error")
+	}
+
+	// round the rate first, so the converted amount can be recomputed from the stored rate
+	rate, _ = new(big.Rat).SetString(rate.FloatString(ratePrecision))
+	if rate.Sign() <= 0 {
+		return ExchangeQuote{}, config.ErrExchangeRateNotFound
+	}
+
+	// the converted amount is rounded down, in favor of the bank
+	converted, err := amount.Convert(toCurrency, rate)
+	if err != nil {
+		return ExchangeQuote{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if converted.Amount <= 0 {
+		return ExchangeQuote{}, config.ErrExchangeAmountTooLow
+	}
+
+	quote := ExchangeQuote{
+		ID:           uuid.NewString(),
+		UserID:       payload.UserID,
+		FromCurrency: fromCurrency,
+		ToCurrency:   toCurrency,
+		Rate:         formatRate(rate),
+		FromAmount:   amount.Amount,
+		ToAmount:     converted.Amount,
+	}
+
+	quote, err = h.balanceRepo.CreateExchangeQuote(ctx, quote, h.quoteTTL)
+	if err != nil {
+		return quote, errors.Wrap(err, "CreateExchangeQuote error")
+	}
+
+	return quote, nil
+}
+
+// CreateExchange executes an exchange quote. Unlike withdrawals and transfers, it doesn't need step-up verification:
+// the money stays in the user's own balances, so it can't be taken out of the account this way.
+func (h *balanceHandler) CreateExchange(c *fiber.Ctx) error {
+	var payload CreateExchangeRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	quote, debitEntity, creditEntity, err := h.createExchange(c.Context(), payload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data: ExchangeResponse{
+			QuoteID: quote.ID,
+			Rate:    quote.Rate,
+			Debit:   newBalanceHistoryResponse(debitEntity),
+			Credit:  newBalanceHistoryResponse(creditEntity),
+		},
+	})
+}
+
+// createExchange executes the quote, debiting the source currency and crediting the target currency
+// of the same user in one transaction
+func (h *balanceHandler) createExchange(ctx context.Context, payload CreateExchangeRequest) (ExchangeQuote, BalanceHistory, BalanceHistory, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return ExchangeQuote{}, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	quote, err := h.balanceRepo.GetExchangeQuoteForUpdate(ctx, tx, payload.QuoteID, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return quote, BalanceHistory{}, BalanceHistory{}, config.ErrQuoteNotFound
+		}
+
+		return quote, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "GetExchangeQuoteForUpdate error")
+	}
+	if quote.ExecutedAt.Valid {
+		return quote, BalanceHistory{}, BalanceHistory{}, config.ErrQuoteUsed
+	}
+	if quote.Expired {
+		return quote, BalanceHistory{}, BalanceHistory{}, config.ErrQuoteExpired
+	}
+
+	// lock both currencies in a fixed order, so concurrent exchanges can't deadlock
+	lockedBalances := map[string]int64{}
+	currencies := []string{quote.FromCurrency, quote.ToCurrency}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		balance, err := h.balanceRepo.GetCurrencyBalanceForUpdate(ctx, tx, quote.UserID, currency)
+		if err != nil {
+			return quote, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "GetCurrencyBalanceForUpdate error")
+		}
+		lockedBalances[currency] = balance
+	}
+
+	// the held amount can't be exchanged
+	held, err := h.getHeldAmount(ctx, tx, quote.UserID, quote.FromCurrency)
+	if err != nil {
+		return quote, BalanceHistory{}, BalanceHistory{}, err
+	}
+	if lockedBalances[quote.FromCurrency]-held < quote.FromAmount {
+		return quote, BalanceHistory{}, BalanceHistory{}, config.ErrInsufficientBalance
+	}
+
+	transferReference := sql.NullString{String: quote.ID, Valid: true}
+	exchangeRate := sql.NullString{String: quote.Rate, Valid: true}
+	debitEntity := BalanceHistory{
+		ID:                      uuid.NewString(),
+		UserID:                  quote.UserID,
+		Currency:                quote.FromCurrency,
+		Balance:                 quote.FromAmount * -1,
+		SourceBankAccountNumber: quote.UserID,
+		SourceBankName:          InternalBankName,
+		Status:                  StatusCompleted,
+		TransferReference:       transferReference,
+		ExchangeRate:            exchangeRate,
+	}
+	creditEntity := BalanceHistory{
+		ID:                      uuid.NewString(),
+		UserID:                  quote.UserID,
+		Currency:                quote.ToCurrency,
+		Balance:                 quote.ToAmount,
+		SourceBankAccountNumber: quote.UserID,
+		SourceBankName:          InternalBankName,
+		Status:                  StatusCompleted,
+		TransferReference:       transferReference,
+		ExchangeRate:            exchangeRate,
+	}
+
+	entry := newJournalEntry(quote.ID, JournalKindExchange, SystemAccountCurrencyExchange, debitEntity, creditEntity)
+	err = h.balanceRepo.PostJournalEntry(ctx, tx, entry, debitEntity, creditEntity)
+	if err != nil {
+		return quote, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "PostJournalEntry error")
+	}
+
+	err = h.balanceRepo.MarkExchangeQuoteExecuted(ctx, tx, quote.ID)
+	if err != nil {
+		return quote, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "MarkExchangeQuoteExecuted error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return quote, BalanceHistory{}, BalanceHistory{}, errors.Wrap(err, "Commit error")
+	}
+
+	return quote, debitEntity, creditEntity, nil
+}
+
+// formatRate writes the rate as a decimal without trailing zeros
+func formatRate(rate *big.Rat) string {
+	formatted := rate.FloatString(ratePrecision)
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
@@ -2,11 +2,15 @@ package balance
 
 import (
 	"context"
-	"strings"
+	"database/sql"
+	"time"
 
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/image"
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -14,27 +18,87 @@ import (
 )
 
 type balanceHandler struct {
-	balanceRepo *balanceRepo
+	balanceRepo           *balanceRepo
+	userRepo              *user.UserRepo
+	imageRepo             *image.ImageRepo
+	trxProvider           *config.TransactionProvider
+	idempotencyMiddleware fiber.Handler
+	auditLogger           user.AuditLogger
+	rateProvider          RateProvider
+	quoteTTL              time.Duration
+	totpVerifier          *user.TOTPVerifier
+	stepUpThrottler       *user.LoginThrottler
+	stepUpThresholds      StepUpThresholds
+	limitRules            LimitRules
 }
 
 type BalanceHandlerConfig struct {
-	BalanceRepo *balanceRepo
+	BalanceRepo           *balanceRepo
+	UserRepo              *user.UserRepo
+	ImageRepo             *image.ImageRepo
+	TrxProvider           *config.TransactionProvider
+	IdempotencyMiddleware fiber.Handler
+	AuditLogger           user.AuditLogger
+	RateProvider          RateProvider
+	QuoteTTL              time.Duration
+	TOTPVerifier          *user.TOTPVerifier
+	StepUpThrottler       *user.LoginThrottler
+	StepUpThresholds      StepUpThresholds
+	LimitRules            LimitRules
 }
 
 func NewBalance(cfg BalanceHandlerConfig) balanceHandler {
-	return balanceHandler{balanceRepo: cfg.BalanceRepo}
+	return balanceHandler{
+		balanceRepo:           cfg.BalanceRepo,
+		userRepo:              cfg.UserRepo,
+		imageRepo:             cfg.ImageRepo,
+		trxProvider:           cfg.TrxProvider,
+		idempotencyMiddleware: cfg.IdempotencyMiddleware,
+		auditLogger:           cfg.AuditLogger,
+		rateProvider:          cfg.RateProvider,
+		quoteTTL:              cfg.QuoteTTL,
+		totpVerifier:          cfg.TOTPVerifier,
+		stepUpThrottler:       cfg.StepUpThrottler,
+		stepUpThresholds:      cfg.StepUpThresholds,
+		limitRules:            cfg.LimitRules,
+	}
 }
 
 func (h *balanceHandler) RegisterRoute(r *fiber.App, jwtProvider jwt.JWTProvider) {
 	authMiddleware := jwtProvider.Middleware()
 
 	balanceGroup := r.Group("/v1/balance")
-	balanceGroup.Post("/", authMiddleware, h.AddBalance)
+	balanceGroup.Post("/", authMiddleware, h.requireActiveAccount, h.idempotencyMiddleware, h.AddBalance)
 	balanceGroup.Get("/", authMiddleware, h.GetBalances)
 	balanceGroup.Get("/history", authMiddleware, h.GetBalanceHistory)
 
 	transactionGroup := r.Group("/v1/transaction")
-	transactionGroup.Post("/", authMiddleware, h.CreateTransaction)
+	transactionGroup.Post("/", authMiddleware, h.requireActiveAccount, h.idempotencyMiddleware, h.CreateTransaction)
+
+	transferGroup := r.Group("/v1/transfer")
+	transferGroup.Post("/", authMiddleware, h.requireActiveAccount, h.idempotencyMiddleware, h.CreateTransfer)
+
+	exchangeGroup := r.Group("/v1/exchange")
+	exchangeGroup.Post("/quote", authMiddleware, h.CreateExchangeQuote)
+	exchangeGroup.Post("/", authMiddleware, h.requireActiveAccount, h.idempotencyMiddleware, h.CreateExchange)
+
+	staffMiddleware := jwt.RequireRoles(jwt.RoleSupport, jwt.RoleAdmin)
+	adminMiddleware := jwt.RequireRoles(jwt.RoleAdmin)
+
+	// reversals move money out of the users' balances, so only admins can post them
+	r.Post("/v1/admin/transactions/:transactionId/reversal", authMiddleware, adminMiddleware, h.ReverseTransaction)
+
+	topUpGroup := r.Group("/v1/admin/topups")
+	topUpGroup.Get("/", authMiddleware, staffMiddleware, h.GetPendingTopUps)
+	topUpGroup.Post("/:transactionId/approve", authMiddleware, staffMiddleware, h.ApproveTopUp)
+	topUpGroup.Post("/:transactionId/reject", authMiddleware, staffMiddleware, h.RejectTopUp)
+
+	adminGroup := r.Group("/v1/admin/users/:userId")
+	adminGroup.Get("/balances", authMiddleware, staffMiddleware, h.GetUserBalances)
+	adminGroup.Get("/balances/history", authMiddleware, staffMiddleware, h.GetUserBalanceHistory)
+	adminGroup.Get("/holds", authMiddleware, staffMiddleware, h.GetHolds)
+	adminGroup.Post("/holds", authMiddleware, staffMiddleware, h.CreateHold)
+	adminGroup.Post("/holds/:holdId/release", authMiddleware, staffMiddleware, h.ReleaseHold)
 }
 
 func (h *balanceHandler) AddBalance(c *fiber.Ctx) error {
@@ -52,42 +116,66 @@ func (h *balanceHandler) AddBalance(c *fiber.Ctx) error {
 	if err := validation.Validate(payload); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
-	comps := strings.Split(payload.TransferProofImg, "/")
-	filename := comps[len(comps)-1]
-	if len(strings.Split(filename, ".")) < 2 {
-		return fiber.ErrBadRequest
+
+	addedBalance, err := parseAmount(payload.AddedBalance, payload.Currency)
+	if err != nil {
+		return err
 	}
 
 	ctx := c.Context()
 
-	// do addition
+	// the top-up stays pending, and only adds to the balance once a staff member approves it
 	transactionID := uuid.NewString()
 	balanceEntity := BalanceHistory{
 		ID:                      transactionID,
 		UserID:                  payload.UserID,
-		Currency:                payload.Currency,
-		Balance:                 int(payload.AddedBalance),
+		Currency:                addedBalance.Currency,
+		Balance:                 addedBalance.Amount,
 		SourceBankAccountNumber: payload.SenderBankAccountNumber,
 		SourceBankName:          payload.SenderBankName,
+		Status:                  StatusPending,
 		TransferProofImg:        payload.TransferProofImg,
 	}
-	err = h.balanceRepo.AddBalance(ctx, nil, balanceEntity)
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// the proof must be an image the user uploaded, and each image only proves a single top-up
+	proofImage, err := h.imageRepo.GetImageByURLForUpdate(ctx, tx, payload.TransferProofImg)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrProofImageNotFound
+		}
+
+		return errors.Wrap(err, "GetImageByURLForUpdate error")
+	}
+	if proofImage.UserID != payload.UserID {
+		return config.ErrProofImageNotFound
+	}
+	if proofImage.UsedBy.Valid {
+		return config.ErrProofImageUsed
+	}
+
+	err = h.balanceRepo.AddBalanceHistory(ctx, tx, balanceEntity)
+	if err != nil {
+		return errors.Wrap(err, "AddBalanceHistory error")
+	}
+
+	err = h.imageRepo.MarkImageUsed(ctx, tx, proofImage.ID, balanceEntity.ID)
+	if err != nil {
+		return errors.Wrap(err, "MarkImageUsed error")
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return errors.Wrap(err, "AddBalance error")
+		return errors.Wrap(err, "Commit error")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
 		Message: "success",
-		Data: BalanceHistoryResponse{
-			TransactionID:    transactionID,
-			Balance:          balanceEntity.Balance,
-			Currency:         balanceEntity.Currency,
-			TransferProofImg: balanceEntity.TransferProofImg,
-			Source: BalanceSourceResponse{
-				BankAccountNumber: balanceEntity.SourceBankAccountNumber,
-				BankName:          balanceEntity.SourceBankName,
-			},
-		},
+		Data:    newBalanceHistoryResponse(balanceEntity),
 	})
 }
 
@@ -97,15 +185,24 @@ func (h *balanceHandler) GetBalances(c *fiber.Ctx) error {
 		return config.ErrRequestForbidden
 	}
 
+	return h.respondBalances(c, claims.UserID)
+}
+
+func (h *balanceHandler) respondBalances(c *fiber.Ctx, userID string) error {
 	ctx := c.Context()
-	currencyBalances, err := h.balanceRepo.GetBalancePerCurrencies(ctx, claims.UserID, "")
+	currencyBalances, err := h.balanceRepo.GetBalancePerCurrencies(ctx, userID, "")
 	if err != nil {
 		return errors.Wrap(err, "GetBalancePerCurrencies error")
 	}
 
 	responses := []CurrencyBalanceResponse{}
 	for _, balance := range currencyBalances {
-		responses = append(responses, CurrencyBalanceResponse(balance))
+		responses = append(responses, CurrencyBalanceResponse{
+			Balance:          money.New(balance.Balance, balance.Currency).Decimal(),
+			Currency:         balance.Currency,
+			AvailableBalance: money.New(balance.Available(), balance.Currency).Decimal(),
+			HeldBalance:      money.New(balance.Held, balance.Currency).Decimal(),
+		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
@@ -115,50 +212,50 @@ func (h *balanceHandler) GetBalances(c *fiber.Ctx) error {
 }
 
 func (h *balanceHandler) GetBalanceHistory(c *fiber.Ctx) error {
-	var payload GetBalanceHistoryRequest
 	claims, err := jwt.GetLoggedInUser(c)
 	if err != nil {
 		return config.ErrRequestForbidden
 	}
-	payload.UserID = claims.UserID
 
+	return h.respondBalanceHistory(c, claims.UserID)
+}
+
+func (h *balanceHandler) respondBalanceHistory(c *fiber.Ctx, userID string) error {
+	var payload GetBalanceHistoryRequest
 	if err := c.QueryParser(&payload); err != nil {
 		return errors.Wrap(config.ErrMalformedRequest, err.Error())
 	}
 	payload.Queries = c.Queries()
+	payload.UserID = userID
 
 	if err := payload.Validate(); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
 	ctx := c.Context()
-	balanceHistories, count, err := h.balanceRepo.GetBalanceHistory(ctx, payload)
+	page, err := h.balanceRepo.GetBalanceHistory(ctx, payload)
 	if err != nil {
 		return errors.Wrap(err, "GetBalanceHistory error")
 	}
 
 	responses := []BalanceHistoryResponse{}
-	for _, balanceEntity := range balanceHistories {
-		responses = append(responses, BalanceHistoryResponse{
-			TransactionID:    balanceEntity.ID,
-			Balance:          balanceEntity.Balance,
-			Currency:         balanceEntity.Currency,
-			TransferProofImg: balanceEntity.TransferProofImg,
-			CreatedAt:        uint64(balanceEntity.CreatedAt.UnixMilli()),
-			Source: BalanceSourceResponse{
-				BankAccountNumber: balanceEntity.SourceBankAccountNumber,
-				BankName:          balanceEntity.SourceBankName,
-			},
-		})
+	for _, balanceEntity := range page.Histories {
+		responses = append(responses, newBalanceHistoryResponse(balanceEntity))
 	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
 		Message: "success",
 		Data:    responses,
 		Meta: &model.ResponseMeta{
-			Limit:  payload.Limit,
-			Offset: payload.Offset,
-			Total:  count,
+			Limit:      payload.Limit,
+			Offset:     payload.Offset,
+			Total:      page.Total,
+			NextCursor: page.NextCursor,
+			PrevCursor: page.PrevCursor,
 		},
 	})
 }
@@ -179,39 +276,53 @@ func (h *balanceHandler) CreateTransaction(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	balanceEntity, err := h.createTransaction(c.Context(), payload)
+	amount, err := parseAmount(payload.Balances, payload.FromCurrency)
+	if err != nil {
+		return err
+	}
+
+	// large amounts leaving the bank need the second factor as well
+	err = h.verifyStepUp(c, payload.UserID, amount)
+	if err != nil {
+		return err
+	}
+
+	balanceEntity, err := h.createTransaction(c.Context(), payload, amount)
 	if err != nil {
 		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
 		Message: "success",
-		Data: BalanceHistoryResponse{
-			TransactionID:    balanceEntity.ID,
-			Balance:          balanceEntity.Balance,
-			Currency:         balanceEntity.Currency,
-			TransferProofImg: balanceEntity.TransferProofImg,
-			CreatedAt:        uint64(balanceEntity.CreatedAt.UnixMilli()),
-			Source: BalanceSourceResponse{
-				BankAccountNumber: balanceEntity.SourceBankAccountNumber,
-				BankName:          balanceEntity.SourceBankName,
-			},
-		},
+		Data:    newBalanceHistoryResponse(balanceEntity),
 	})
 }
 
-func (h *balanceHandler) createTransaction(ctx context.Context, payload CreateTransactionRequest) (BalanceHistory, error) {
-	// first, validate if the budget does exist
-	currencyBudgets, err := h.balanceRepo.GetBalancePerCurrencies(ctx, payload.UserID, payload.FromCurrency)
+func (h *balanceHandler) createTransaction(ctx context.Context, payload CreateTransactionRequest, amount money.Money) (BalanceHistory, error) {
+	normalizedCurrency := amount.Currency
+
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// validate if the budget does exist. This locks the balance, so concurrent
+	// transactions can't both pass the check. The held amount can't be withdrawn.
+	availableBalance, err := h.getAvailableBalanceForUpdate(ctx, tx, payload.UserID, normalizedCurrency)
 	if err != nil {
-		return BalanceHistory{}, errors.Wrap(err, "GetBalancePerCurrencies error")
+		return BalanceHistory{}, err
 	}
-	if len(currencyBudgets) != 1 || (currencyBudgets[0].Balance < int(payload.Balances)) {
+	if availableBalance < amount.Amount {
 		return BalanceHistory{}, config.ErrInsufficientBalance
 	}
 
-	normalizedCurrency := strings.ToUpper(payload.FromCurrency)
-	deductedBalance := int(payload.Balances) * -1
+	err = h.checkLimits(ctx, tx, payload.UserID, amount)
+	if err != nil {
+		return BalanceHistory{}, err
+	}
+
+	deductedBalance := amount.Amount * -1
 	// then, save the balance history
 	transactionID := uuid.NewString()
 	balanceEntity := BalanceHistory{
@@ -222,11 +333,31 @@ func (h *balanceHandler) createTransaction(ctx context.Context, payload CreateTr
 		TransferProofImg:        "",
 		SourceBankAccountNumber: payload.RecipientBankAccountNumber,
 		SourceBankName:          payload.RecipientBankName,
+		Status:                  StatusCompleted,
+	}
+	entry := newJournalEntry(transactionID, JournalKindWithdrawal, SystemAccountExternalOutflow, balanceEntity)
+	err = h.balanceRepo.PostJournalEntry(ctx, tx, entry, balanceEntity)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "PostJournalEntry error")
 	}
-	err = h.balanceRepo.AddBalance(ctx, nil, balanceEntity)
+
+	err = tx.Commit()
 	if err != nil {
-		return BalanceHistory{}, errors.Wrap(err, "AddBalance error")
+		return BalanceHistory{}, errors.Wrap(err, "Commit error")
 	}
 
 	return balanceEntity, nil
 }
+
+// parseAmount converts the requested amount into the minor unit of the currency. The amount must be positive.
+func parseAmount(value money.Decimal, currency string) (money.Money, error) {
+	amount, err := value.Money(currency)
+	if err != nil {
+		return amount, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if amount.Amount <= 0 {
+		return amount, fiber.NewError(fiber.StatusBadRequest, "amount must be greater than 0")
+	}
+
+	return amount, nil
+}
@@ -0,0 +1,209 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// GetHolds lists the holds on the user's balances. It's only available for support staff and admins.
+func (h *balanceHandler) GetHolds(c *fiber.Ctx) error {
+	var payload GetHoldsRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := c.QueryParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	holds, err := h.balanceRepo.GetHolds(c.Context(), payload)
+	if err != nil {
+		return errors.Wrap(err, "GetHolds error")
+	}
+
+	responses := []HoldResponse{}
+	for _, hold := range holds {
+		responses = append(responses, newHoldResponse(hold))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    responses,
+		Meta: &model.ResponseMeta{
+			Limit:  payload.Limit,
+			Offset: payload.Offset,
+		},
+	})
+}
+
+// CreateHold reserves an amount of the user's available balance. It's only available for support staff and admins.
+func (h *balanceHandler) CreateHold(c *fiber.Ctx) error {
+	var payload CreateHoldRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.CreatedBy = claims.UserID
+
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	amount, err := parseAmount(payload.Amount, payload.Currency)
+	if err != nil {
+		return err
+	}
+
+	hold, err := h.createHold(c.Context(), payload, amount)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newHoldResponse(hold),
+	})
+}
+
+func (h *balanceHandler) createHold(ctx context.Context, payload CreateHoldRequest, amount money.Money) (Hold, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// the hold can only reserve what's still available, and is created under the same lock as the debits
+	available, err := h.getAvailableBalanceForUpdate(ctx, tx, payload.UserID, amount.Currency)
+	if err != nil {
+		return Hold{}, err
+	}
+	if available < amount.Amount {
+		return Hold{}, config.ErrInsufficientBalance
+	}
+
+	hold := Hold{
+		ID:        uuid.NewString(),
+		UserID:    payload.UserID,
+		Currency:  amount.Currency,
+		Amount:    amount.Amount,
+		Reason:    payload.Reason,
+		CreatedBy: payload.CreatedBy,
+	}
+	err = h.balanceRepo.CreateHold(ctx, tx, hold)
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "CreateHold error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "Commit error")
+	}
+
+	return hold, nil
+}
+
+// ReleaseHold makes the held amount available again. It's only available for support staff and admins.
+func (h *balanceHandler) ReleaseHold(c *fiber.Ctx) error {
+	var payload ReleaseHoldRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.ReleasedBy = claims.UserID
+
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	hold, err := h.releaseHold(c.Context(), payload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newHoldResponse(hold),
+	})
+}
+
+func (h *balanceHandler) releaseHold(ctx context.Context, payload ReleaseHoldRequest) (Hold, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// locking the hold makes sure it's only released once
+	hold, err := h.balanceRepo.GetHoldForUpdate(ctx, tx, payload.HoldID, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Hold{}, config.ErrHoldNotFound
+		}
+
+		return Hold{}, errors.Wrap(err, "GetHoldForUpdate error")
+	}
+	if hold.ReleasedAt.Valid {
+		return Hold{}, config.ErrHoldReleased
+	}
+
+	hold, err = h.balanceRepo.ReleaseHold(ctx, tx, hold.ID, payload.ReleasedBy)
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "ReleaseHold error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Hold{}, errors.Wrap(err, "Commit error")
+	}
+
+	return hold, nil
+}
+
+// getAvailableBalanceForUpdate locks the user's balance in a currency, and returns the part of it which isn't held
+func (h *balanceHandler) getAvailableBalanceForUpdate(ctx context.Context, tx *sql.Tx, userID, currency string) (int64, error) {
+	balance, err := h.balanceRepo.GetCurrencyBalanceForUpdate(ctx, tx, userID, currency)
+	if err != nil {
+		return 0, errors.Wrap(err, "GetCurrencyBalanceForUpdate error")
+	}
+
+	held, err := h.getHeldAmount(ctx, tx, userID, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance - held, nil
+}
+
+func (h *balanceHandler) getHeldAmount(ctx context.Context, tx *sql.Tx, userID, currency string) (int64, error) {
+	held, err := h.balanceRepo.GetHeldAmount(ctx, tx, userID, currency)
+	if err != nil {
+		return 0, errors.Wrap(err, "GetHeldAmount error")
+	}
+
+	return held, nil
+}
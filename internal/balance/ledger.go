@@ -0,0 +1,116 @@
+package balance
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	JournalKindTopUp      = "topup"
+	JournalKindWithdrawal = "withdrawal"
+	JournalKindTransfer   = "transfer"
+	JournalKindExchange   = "exchange"
+	JournalKindReversal   = "reversal"
+)
+
+// system accounts are the other side of money entering, leaving, or changing currency in the bank
+const (
+	SystemAccountExternalInflow   = "system:external-bank-inflow"
+	SystemAccountExternalOutflow  = "system:external-bank-outflow"
+	SystemAccountCurrencyExchange = "system:currency-exchange"
+)
+
+var ErrUnbalancedJournalEntry = errors.New("journal entry postings don't sum to zero")
+
+// JournalEntry is a single movement of money in the ledger. Its postings must sum to zero in every currency.
+type JournalEntry struct {
+	ID       string    `db:"id"`
+	Kind     string    `db:"kind"`
+	Postings []Posting `db:"-"`
+
+	// ReversalOf is the ID of the entry this entry reverses, if it's a reversal
+	ReversalOf string `db:"reversal_of"`
+	// ReversedBy is the ID of the entry reversing this entry. It's only read, never written.
+	ReversedBy string `db:"reversed_by"`
+
+	// CreatedBy is the staff member who posted the entry, e.g. the admin reversing a transaction
+	CreatedBy string `db:"created_by"`
+}
+
+type Posting struct {
+	AccountID string `db:"account_id"`
+	Currency  string `db:"currency"`
+	Amount    int64  `db:"amount"`
+
+	// UserID is only set for customer accounts
+	UserID string `db:"user_id"`
+}
+
+func CustomerAccountID(userID string) string {
+	return "user:" + userID
+}
+
+// newJournalEntry builds the entry behind the balance histories: each history is posted to its user's account,
+// and offset by a posting to the system account if there's one. Without a system account,
+// the histories have to offset each other.
+func newJournalEntry(id, kind, systemAccountID string, histories ...BalanceHistory) JournalEntry {
+	entry := JournalEntry{
+		ID:   id,
+		Kind: kind,
+	}
+
+	for _, history := range histories {
+		entry.Postings = append(entry.Postings, Posting{
+			AccountID: CustomerAccountID(history.UserID),
+			UserID:    history.UserID,
+			Currency:  history.Currency,
+			Amount:    history.Balance,
+		})
+
+		if systemAccountID != "" {
+			entry.Postings = append(entry.Postings, Posting{
+				AccountID: systemAccountID,
+				Currency:  history.Currency,
+				Amount:    history.Balance * -1,
+			})
+		}
+	}
+
+	return entry
+}
+
+// Validate makes sure the entry conserves money: it has at least two postings, summing to zero per currency
+func (e JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return errors.Wrap(ErrUnbalancedJournalEntry, "at least two postings are required")
+	}
+
+	sums := map[string]int64{}
+	for _, posting := range e.Postings {
+		sums[posting.Currency] += posting.Amount
+	}
+
+	for currency, sum := range sums {
+		if sum != 0 {
+			return errors.Wrapf(ErrUnbalancedJournalEntry, "%s postings sum to %d", currency, sum)
+		}
+	}
+
+	return nil
+}
+
+// Reverse builds the compensating entry of e, which negates all of its postings
+func (e JournalEntry) Reverse(id, createdBy string) JournalEntry {
+	reversal := JournalEntry{
+		ID:         id,
+		Kind:       JournalKindReversal,
+		ReversalOf: e.ID,
+		CreatedBy:  createdBy,
+	}
+
+	for _, posting := range e.Postings {
+		posting.Amount *= -1
+		reversal.Postings = append(reversal.Postings, posting)
+	}
+
+	return reversal
+}
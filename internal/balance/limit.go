@@ -0,0 +1,189 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+	"github.com/pkg/errors"
+)
+
+// CurrencyLimits are the outgoing amounts allowed in a currency, in minor units. A zero amount has no limit.
+type CurrencyLimits struct {
+	PerTransaction int64
+	Daily          int64
+	Monthly        int64
+}
+
+// TierLimits are the limits of the users in a tier. Currencies without limits are unlimited.
+type TierLimits struct {
+	// TransactionsPerHour is how many outgoing transactions, in any currency, can be made in an hour. Zero has no limit.
+	TransactionsPerHour int64
+	Currencies          map[string]CurrencyLimits
+}
+
+// OutboundUsage is what the user has already sent out, which counts against the limits
+type OutboundUsage struct {
+	// DailyAmount and MonthlyAmount are in the currency of the transaction, since the start of the day and the month
+	DailyAmount   int64 `db:"daily_amount"`
+	MonthlyAmount int64 `db:"monthly_amount"`
+	// HourlyCount is the number of outgoing transactions in any currency within the last hour
+	HourlyCount int64 `db:"hourly_count"`
+}
+
+// LimitRules decides whether an outgoing transaction is within the limits of the user's tier
+type LimitRules struct {
+	defaultTier string
+	tiers       map[string]TierLimits
+}
+
+type limitsFile struct {
+	DefaultTier string `json:"defaultTier"`
+	Tiers       map[string]struct {
+		TransactionsPerHour int64 `json:"transactionsPerHour"`
+		Currencies          map[string]struct {
+			PerTransaction string `json:"perTransaction"`
+			Daily          string `json:"daily"`
+			Monthly        string `json:"monthly"`
+		} `json:"currencies"`
+	} `json:"tiers"`
+}
+
+// NewLimitRules builds the rules from the limits per tier. Users whose tier isn't listed get the default tier's.
+func NewLimitRules(defaultTier string, tiers map[string]TierLimits) (LimitRules, error) {
+	if _, ok := tiers[defaultTier]; len(tiers) > 0 && !ok {
+		return LimitRules{}, fmt.Errorf("default tier %q has no limits", defaultTier)
+	}
+
+	return LimitRules{defaultTier: defaultTier, tiers: tiers}, nil
+}
+
+// NewFileLimitRules reads the rules from a JSON file, with the amounts written in the major unit, e.g.
+// {"defaultTier": "standard", "tiers": {"standard": {"transactionsPerHour": 10, "currencies": {"USD": {"daily": "5000"}}}}}.
+// Without a file, there are no limits at all.
+func NewFileLimitRules(path string) (LimitRules, error) {
+	if path == "" {
+		return NewLimitRules("", nil)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return LimitRules{}, errors.Wrap(err, "read limits file error")
+	}
+
+	var file limitsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return LimitRules{}, errors.Wrap(err, "parse limits file error")
+	}
+
+	tiers := map[string]TierLimits{}
+	for tier, tierFile := range file.Tiers {
+		limits := TierLimits{
+			TransactionsPerHour: tierFile.TransactionsPerHour,
+			Currencies:          map[string]CurrencyLimits{},
+		}
+
+		for currency, currencyFile := range tierFile.Currencies {
+			currency = strings.ToUpper(currency)
+
+			var currencyLimits CurrencyLimits
+			for _, field := range []struct {
+				name   string
+				value  string
+				target *int64
+			}{
+				{"perTransaction", currencyFile.PerTransaction, &currencyLimits.PerTransaction},
+				{"daily", currencyFile.Daily, &currencyLimits.Daily},
+				{"monthly", currencyFile.Monthly, &currencyLimits.Monthly},
+			} {
+				if field.value == "" {
+					continue
+				}
+
+				amount, err := money.Parse(field.value, currency)
+				if err != nil {
+					return LimitRules{}, fmt.Errorf("%s limit of %s in tier %s: %s", field.name, currency, tier, err.Error())
+				}
+				if amount.Amount <= 0 {
+					return LimitRules{}, fmt.Errorf("%s limit of %s in tier %s must be greater than 0", field.name, currency, tier)
+				}
+				*field.target = amount.Amount
+			}
+
+			limits.Currencies[currency] = currencyLimits
+		}
+
+		tiers[tier] = limits
+	}
+
+	return NewLimitRules(file.DefaultTier, tiers)
+}
+
+// Evaluate checks the amount against the limits of the tier, given what the user has already sent out
+func (r *LimitRules) Evaluate(tier string, amount money.Money, usage OutboundUsage) error {
+	limits, ok := r.tiers[tier]
+	if !ok {
+		limits, ok = r.tiers[r.defaultTier]
+		if !ok {
+			return nil
+		}
+	}
+
+	if limits.TransactionsPerHour > 0 && usage.HourlyCount+1 > limits.TransactionsPerHour {
+		return &config.LimitExceededError{
+			Limit: config.LimitHourlyCount,
+			Max:   fmt.Sprint(limits.TransactionsPerHour),
+		}
+	}
+
+	currencyLimits, ok := limits.Currencies[amount.Currency]
+	if !ok {
+		return nil
+	}
+
+	for _, check := range []struct {
+		limit string
+		max   int64
+		total int64
+	}{
+		{config.LimitPerTransaction, currencyLimits.PerTransaction, amount.Amount},
+		{config.LimitDaily, currencyLimits.Daily, usage.DailyAmount + amount.Amount},
+		{config.LimitMonthly, currencyLimits.Monthly, usage.MonthlyAmount + amount.Amount},
+	} {
+		if check.max > 0 && check.total > check.max {
+			return &config.LimitExceededError{
+				Limit:    check.limit,
+				Max:      money.New(check.max, amount.Currency).String(),
+				Currency: amount.Currency,
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkLimits evaluates the limits of an outgoing transaction. The checks of the user are serialized until tx ends,
+// so concurrent transactions are counted against each other, even in other currencies for the hourly count.
+func (h *balanceHandler) checkLimits(ctx context.Context, tx *sql.Tx, userID string, amount money.Money) error {
+	sender, err := h.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "GetUserByID error")
+	}
+
+	err = h.balanceRepo.LockOutboundLimits(ctx, tx, userID)
+	if err != nil {
+		return errors.Wrap(err, "LockOutboundLimits error")
+	}
+
+	usage, err := h.balanceRepo.GetOutboundUsage(ctx, tx, userID, amount.Currency)
+	if err != nil {
+		return errors.Wrap(err, "GetOutboundUsage error")
+	}
+
+	return h.limitRules.Evaluate(sender.Tier, amount, usage)
+}
@@ -1,64 +1,306 @@
 package balance
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
 )
 
 type AddBalanceRequest struct {
-	SenderBankAccountNumber string `json:"senderBankAccountNumber" validate:"required,min=5,max=30"`
-	SenderBankName          string `json:"senderBankName" validate:"required,min=5,max=30"`
-	AddedBalance            uint   `json:"addedBalance" validate:"required,gte=0"`
-	Currency                string `json:"currency" validate:"required,iso4217"`
-	TransferProofImg        string `json:"transferProofImg" validate:"required,url"`
+	SenderBankAccountNumber string        `json:"senderBankAccountNumber" validate:"required,min=5,max=30"`
+	SenderBankName          string        `json:"senderBankName" validate:"required,min=5,max=30"`
+	AddedBalance            money.Decimal `json:"addedBalance" validate:"required"`
+	Currency                string        `json:"currency" validate:"required,iso4217"`
+	TransferProofImg        string        `json:"transferProofImg" validate:"required,url"`
 
 	UserID string
 }
 
+const (
+	DirectionCredit = "credit"
+	DirectionDebit  = "debit"
+
+	SortByCreatedAt = "createdAt"
+	SortByBalance   = "balance"
+
+	SortOrderAsc  = "asc"
+	SortOrderDesc = "desc"
+)
+
+const (
+	// top-ups are pending until a support staff member or an admin approves or rejects them
+	StatusPending  = "PENDING"
+	StatusApproved = "APPROVED"
+	StatusRejected = "REJECTED"
+
+	// every other movement is completed right away
+	StatusCompleted = "COMPLETED"
+)
+
 type GetBalanceHistoryRequest struct {
 	Limit  uint `query:"limit"`
 	Offset uint `query:"offset"`
 
+	// filters
+	Currency          string `query:"currency" validate:"omitempty,iso4217"`
+	Direction         string `query:"direction" validate:"omitempty,oneof=credit debit"`
+	Status            string `query:"status" validate:"omitempty,oneof=PENDING APPROVED REJECTED COMPLETED"`
+	CreatedAtFrom     uint64 `query:"createdAtFrom"`
+	CreatedAtTo       uint64 `query:"createdAtTo" validate:"omitempty,gtefield=CreatedAtFrom"`
+	BankName          string `query:"bankName" validate:"omitempty,max=30"`
+	BankAccountNumber string `query:"bankAccountNumber" validate:"omitempty,max=30"`
+
+	// amounts are written in the major unit of the currency, so they require the currency filter
+	MinAmount money.Decimal `query:"minAmount"`
+	MaxAmount money.Decimal `query:"maxAmount"`
+
+	// sorting
+	SortBy    string `query:"sortBy" validate:"omitempty,oneof=createdAt balance"`
+	SortOrder string `query:"sortOrder" validate:"omitempty,oneof=asc desc"`
+
+	// Cursor is an alternative to offset, returned as nextCursor/prevCursor by the previous page
+	Cursor string `query:"cursor"`
+	// SkipTotal lets the client opt out of counting the total rows
+	SkipTotal bool `query:"skipTotal"`
+
 	UserID  string
 	Queries map[string]string
+
+	cursor    *historyCursor
+	minAmount *money.Money
+	maxAmount *money.Money
+}
+
+var balanceHistoryQueryKeys = []string{
+	"limit", "offset", "currency", "direction", "status", "createdAtFrom", "createdAtTo",
+	"minAmount", "maxAmount", "bankName", "bankAccountNumber", "sortBy", "sortOrder",
+	"cursor", "skipTotal",
 }
 
 // Validate is a function for additional validation related to query
 func (r *GetBalanceHistoryRequest) Validate() error {
 	queries := r.Queries
 
-	if val, ok := queries["limit"]; ok && val == "" {
-		return errors.New("limit is empty")
+	for _, key := range balanceHistoryQueryKeys {
+		if val, ok := queries[key]; ok && val == "" {
+			return fmt.Errorf("%s is empty", key)
+		}
 	}
 
-	if val, ok := queries["offset"]; ok && val == "" {
-		return errors.New("offset is empty")
+	minAmount, err := r.parseAmountFilter("minAmount", r.MinAmount)
+	if err != nil {
+		return err
+	}
+	maxAmount, err := r.parseAmountFilter("maxAmount", r.MaxAmount)
+	if err != nil {
+		return err
+	}
+	if minAmount != nil && maxAmount != nil && maxAmount.Amount < minAmount.Amount {
+		return errors.New("maxAmount is lower than minAmount")
+	}
+	r.minAmount, r.maxAmount = minAmount, maxAmount
+
+	if r.Cursor != "" {
+		if r.Offset > 0 {
+			return errors.New("cursor can't be used together with offset")
+		}
+
+		// the cursor only points to a position in the created_at order
+		if r.SortBy != "" && r.SortBy != SortByCreatedAt {
+			return errors.New("cursor can only be used when sorting by createdAt")
+		}
+
+		cursor, err := decodeHistoryCursor(r.Cursor)
+		if err != nil {
+			return err
+		}
+		r.cursor = &cursor
 	}
 
 	return nil
 }
 
+func (r *GetBalanceHistoryRequest) parseAmountFilter(key string, value money.Decimal) (*money.Money, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if r.Currency == "" {
+		return nil, fmt.Errorf("%s requires currency", key)
+	}
+
+	amount, err := value.Money(r.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", key, err.Error())
+	}
+
+	return &amount, nil
+}
+
 type CreateTransactionRequest struct {
-	RecipientBankAccountNumber string `json:"recipientBankAccountNumber" validate:"required,min=5,max=30"`
-	RecipientBankName          string `json:"recipientBankName" validate:"required,min=5,max=30"`
-	FromCurrency               string `json:"fromCurrency" validate:"required,iso4217"`
-	Balances                   uint   `json:"balances" validate:"required,gt=0"`
+	RecipientBankAccountNumber string        `json:"recipientBankAccountNumber" validate:"required,min=5,max=30"`
+	RecipientBankName          string        `json:"recipientBankName" validate:"required,min=5,max=30"`
+	FromCurrency               string        `json:"fromCurrency" validate:"required,iso4217"`
+	Balances                   money.Decimal `json:"balances" validate:"required"`
 
 	UserID string
 }
 
+type CreateTransferRequest struct {
+	RecipientEmail  string        `json:"recipientEmail" validate:"required_without=RecipientUserID,excluded_with=RecipientUserID,omitempty,email"`
+	RecipientUserID string        `json:"recipientUserId" validate:"required_without=RecipientEmail,omitempty,uuid"`
+	Currency        string        `json:"currency" validate:"required,iso4217"`
+	Amount          money.Decimal `json:"amount" validate:"required"`
+
+	UserID string
+}
+
+type GetPendingTopUpsRequest struct {
+	Limit  uint `query:"limit"`
+	Offset uint `query:"offset"`
+}
+
+type ReviewTopUpRequest struct {
+	TransactionID string `params:"transactionId" validate:"required,uuid"`
+	Reason        string `json:"reason" validate:"max=256"`
+
+	ReviewedBy string
+	IPAddress  string
+}
+
+type ReverseTransactionRequest struct {
+	TransactionID string `params:"transactionId" validate:"required,uuid"`
+
+	ReversedBy string
+	IPAddress  string
+}
+
+type CreateExchangeQuoteRequest struct {
+	FromCurrency string        `json:"fromCurrency" validate:"required,iso4217"`
+	ToCurrency   string        `json:"toCurrency" validate:"required,iso4217,nefield=FromCurrency"`
+	Amount       money.Decimal `json:"amount" validate:"required"`
+
+	UserID string
+}
+
+type CreateExchangeRequest struct {
+	QuoteID string `json:"quoteId" validate:"required,uuid"`
+
+	UserID string
+}
+
+// InternalBankName is the bank name recorded for transfers between PaimonBank users
+const InternalBankName = "PaimonBank"
+
 type BalanceHistory struct {
-	ID                      string    `db:"id"`
-	UserID                  string    `db:"user_id"`
-	Currency                string    `db:"currency"`
-	Balance                 int       `db:"balance"`
-	SourceBankAccountNumber string    `db:"source_bank_account_number"`
-	SourceBankName          string    `db:"source_bank_name"`
-	TransferProofImg        string    `db:"transfer_proof_img_url"`
-	CreatedAt               time.Time `db:"created_at"`
+	ID                      string         `db:"id"`
+	UserID                  string         `db:"user_id"`
+	Currency                string         `db:"currency"`
+	Balance                 int64          `db:"balance"`
+	SourceBankAccountNumber string         `db:"source_bank_account_number"`
+	SourceBankName          string         `db:"source_bank_name"`
+	TransferProofImg        string         `db:"transfer_proof_img_url"`
+	TransferReference       sql.NullString `db:"transfer_reference"`
+	ExchangeRate            sql.NullString `db:"exchange_rate"`
+	JournalEntryID          sql.NullString `db:"journal_entry_id"`
+	ReversalOf              sql.NullString `db:"reversal_of"`
+	Status                  string         `db:"status"`
+	StatusReason            sql.NullString `db:"status_reason"`
+	ReviewedBy              sql.NullString `db:"reviewed_by"`
+	CreatedAt               time.Time      `db:"created_at"`
+
+	// ReversedBy is the ID of the history reversing this one. It's only read, never written.
+	ReversedBy sql.NullString `db:"reversed_by"`
+}
+
+type ExchangeQuote struct {
+	ID           string       `db:"id"`
+	UserID       string       `db:"user_id"`
+	FromCurrency string       `db:"from_currency"`
+	ToCurrency   string       `db:"to_currency"`
+	Rate         string       `db:"rate"`
+	FromAmount   int64        `db:"from_amount"`
+	ToAmount     int64        `db:"to_amount"`
+	ExpiresAt    time.Time    `db:"expires_at"`
+	ExecutedAt   sql.NullTime `db:"executed_at"`
+
+	// Expired is computed by the database, so it's compared against the same clock which set ExpiresAt
+	Expired bool `db:"expired"`
 }
 
 type BalancePerCurrency struct {
-	Balance  int    `db:"balance_per_currency"`
+	Balance  int64  `db:"balance_per_currency"`
 	Currency string `db:"currency"`
+
+	// Held is the part of the balance reserved by the active holds
+	Held int64 `db:"held_amount"`
+}
+
+// Available is the part of the balance which can be moved
+func (b BalancePerCurrency) Available() int64 {
+	return b.Balance - b.Held
+}
+
+type BalanceMismatch struct {
+	UserID        string `db:"user_id"`
+	Currency      string `db:"currency"`
+	BalanceAmount int64  `db:"balance_amount"`
+	LedgerAmount  int64  `db:"ledger_amount"`
+}
+
+type UnbalancedJournalEntry struct {
+	JournalEntryID string `db:"journal_entry_id"`
+	Currency       string `db:"currency"`
+	TotalAmount    int64  `db:"total_amount"`
+}
+
+type BalanceHistoryPage struct {
+	Histories  []BalanceHistory
+	Total      *uint
+	NextCursor string
+	PrevCursor string
+}
+
+// AdminUserRequest is for the admin requests about a single user
+type AdminUserRequest struct {
+	UserID string `params:"userId" validate:"required,uuid"`
+}
+
+type CreateHoldRequest struct {
+	UserID   string        `params:"userId" validate:"required,uuid"`
+	Amount   money.Decimal `json:"amount" validate:"required"`
+	Currency string        `json:"currency" validate:"required,iso4217"`
+	Reason   string        `json:"reason" validate:"required,max=256"`
+
+	CreatedBy string
+}
+
+type GetHoldsRequest struct {
+	UserID          string `params:"userId" validate:"required,uuid"`
+	IncludeReleased bool   `query:"includeReleased"`
+	Limit           uint   `query:"limit"`
+	Offset          uint   `query:"offset"`
+}
+
+type ReleaseHoldRequest struct {
+	UserID string `params:"userId" validate:"required,uuid"`
+	HoldID string `params:"holdId" validate:"required,uuid"`
+
+	ReleasedBy string
+}
+
+// Hold reserves an amount of the user's balance, so it can't be moved until the hold is released
+type Hold struct {
+	ID         string         `db:"id"`
+	UserID     string         `db:"user_id"`
+	Currency   string         `db:"currency"`
+	Amount     int64          `db:"amount"`
+	Reason     string         `db:"reason"`
+	CreatedBy  string         `db:"created_by"`
+	ReleasedBy sql.NullString `db:"released_by"`
+	ReleasedAt sql.NullTime   `db:"released_at"`
+	CreatedAt  time.Time      `db:"created_at"`
 }
@@ -0,0 +1,78 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// RateProvider supplies the rate to convert 1 unit of a currency into another currency
+type RateProvider interface {
+	GetRate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// StaticRateProvider serves a fixed set of rates. A missing pair is derived from its inverse if available.
+type StaticRateProvider struct {
+	rates map[string]map[string]*big.Rat
+}
+
+// NewStaticRateProvider builds the provider from rates keyed by the source then the target currency,
+// written as decimal strings
+func NewStaticRateProvider(rates map[string]map[string]string) (StaticRateProvider, error) {
+	provider := StaticRateProvider{rates: map[string]map[string]*big.Rat{}}
+
+	for from, targets := range rates {
+		from = strings.ToUpper(from)
+		if _, ok := provider.rates[from]; !ok {
+			provider.rates[from] = map[string]*big.Rat{}
+		}
+
+		for to, value := range targets {
+			rate, ok := new(big.Rat).SetString(value)
+			if !ok || rate.Sign() <= 0 {
+				return provider, errors.Errorf("invalid rate %q for %s to %s", value, from, to)
+			}
+
+			provider.rates[from][strings.ToUpper(to)] = rate
+		}
+	}
+
+	return provider, nil
+}
+
+// NewFileRateProvider reads the static rates from a JSON file, e.g. {"USD": {"IDR": "15850"}}.
+// Without a file, the provider has no rates at all.
+func NewFileRateProvider(path string) (StaticRateProvider, error) {
+	rates := map[string]map[string]string{}
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return StaticRateProvider{}, errors.Wrap(err, "read rates file error")
+		}
+
+		if err := json.Unmarshal(raw, &rates); err != nil {
+			return StaticRateProvider{}, errors.Wrap(err, "parse rates file error")
+		}
+	}
+
+	return NewStaticRateProvider(rates)
+}
+
+func (p *StaticRateProvider) GetRate(_ context.Context, from, to string) (*big.Rat, error) {
+	if rate, ok := p.rates[from][to]; ok {
+		return new(big.Rat).Set(rate), nil
+	}
+
+	if inverse, ok := p.rates[to][from]; ok {
+		return new(big.Rat).Inv(inverse), nil
+	}
+
+	return nil, ErrRateNotFound
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -16,13 +17,114 @@ func NewBalanceRepo(db *sqlx.DB) balanceRepo {
 	return balanceRepo{db: db}
 }
 
-func (r *balanceRepo) AddBalance(ctx context.Context, tx *sql.Tx, val BalanceHistory) error {
+// txx wraps the transaction, so its rows can be scanned into structs like the ones read from db
+func (r *balanceRepo) txx(tx *sql.Tx) *sqlx.Tx {
+	return &sqlx.Tx{Tx: tx, Mapper: r.db.Mapper}
+}
+
+// PostJournalEntry records the entry in the ledger together with the balance histories it's shown as,
+// and applies its postings to the users' materialized balances.
+// Every write must land together, so it has to be called within a transaction.
+func (r *balanceRepo) PostJournalEntry(ctx context.Context, tx *sql.Tx, entry JournalEntry, histories ...BalanceHistory) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	entryQuery := `
+		INSERT INTO
+			journal_entries
+			(id, kind, reversal_of, created_by)
+		VALUES
+			($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+	`
+
+	_, err := tx.ExecContext(ctx, entryQuery, entry.ID, entry.Kind, entry.ReversalOf, entry.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	for _, posting := range entry.Postings {
+		err = r.addPosting(ctx, tx, entry.ID, posting)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, history := range histories {
+		history.JournalEntryID = sql.NullString{String: entry.ID, Valid: true}
+		err = r.AddBalanceHistory(ctx, tx, history)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *balanceRepo) addPosting(ctx context.Context, tx *sql.Tx, journalEntryID string, posting Posting) error {
+	if posting.UserID != "" {
+		// customer accounts are opened on their first posting
+		accountQuery := `
+			INSERT INTO
+				ledger_accounts
+				(id, user_id, kind)
+			VALUES
+				($1, $2, 'customer')
+			ON CONFLICT (id) DO NOTHING
+		`
+
+		_, err := tx.ExecContext(ctx, accountQuery, posting.AccountID, posting.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	postingQuery := `
+		INSERT INTO
+			postings
+			(journal_entry_id, account_id, currency, amount)
+		VALUES
+			($1, $2, $3, $4)
+	`
+
+	_, err := tx.ExecContext(ctx, postingQuery, journalEntryID, posting.AccountID, posting.Currency, posting.Amount)
+	if err != nil {
+		return err
+	}
+
+	if posting.UserID == "" {
+		return nil
+	}
+
+	balanceQuery := `
+		INSERT INTO
+			user_balances
+			(user_id, currency, amount, version)
+		VALUES
+			($1, $2, $3, 1)
+		ON CONFLICT (user_id, currency) DO UPDATE SET
+			amount = user_balances.amount + EXCLUDED.amount,
+			version = user_balances.version + 1,
+			updated_at = NOW()
+	`
+
+	_, err = tx.ExecContext(ctx, balanceQuery, posting.UserID, posting.Currency, posting.Amount)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddBalanceHistory only records the history, without posting it to the ledger.
+// Most movements should go through PostJournalEntry instead.
+func (r *balanceRepo) AddBalanceHistory(ctx context.Context, tx *sql.Tx, val BalanceHistory) error {
 	baseQuery := `
 		INSERT INTO
 			balance_histories
-			(id, user_id, currency, balance, source_bank_account_number, source_bank_name, transfer_proof_img_url)
+			(id, user_id, currency, balance, source_bank_account_number, source_bank_name, transfer_proof_img_url, transfer_reference, exchange_rate, journal_entry_id, reversal_of, status)
 		VALUES
-			(:id, :user_id, :currency, :balance, :source_bank_account_number, :source_bank_name, :transfer_proof_img_url)
+			(:id, :user_id, :currency, :balance, :source_bank_account_number, :source_bank_name, :transfer_proof_img_url, :transfer_reference, :exchange_rate, :journal_entry_id, :reversal_of, :status)
 	`
 
 	query, args, err := sqlx.Named(baseQuery, val)
@@ -30,13 +132,7 @@ func (r *balanceRepo) AddBalance(ctx context.Context, tx *sql.Tx, val BalanceHis
 		return err
 	}
 
-	dollarQuery := sqlx.Rebind(sqlx.DOLLAR, query)
-
-	if tx != nil {
-		_, err = tx.ExecContext(ctx, dollarQuery, args...)
-	} else {
-		_, err = r.db.ExecContext(ctx, dollarQuery, args...)
-	}
+	_, err = tx.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, query), args...)
 	if err != nil {
 		return err
 	}
@@ -44,8 +140,8 @@ func (r *balanceRepo) AddBalance(ctx context.Context, tx *sql.Tx, val BalanceHis
 	return nil
 }
 
-func (r *balanceRepo) GetBalanceHistory(ctx context.Context, payload GetBalanceHistoryRequest) ([]BalanceHistory, uint, error) {
-	var balanceHistories []BalanceHistory
+func (r *balanceRepo) GetBalanceHistory(ctx context.Context, payload GetBalanceHistoryRequest) (BalanceHistoryPage, error) {
+	var page BalanceHistoryPage
 
 	baseQuery := `
 		SELECT
@@ -56,9 +152,17 @@ func (r *balanceRepo) GetBalanceHistory(ctx context.Context, payload GetBalanceH
 			bh.source_bank_account_number,
 			bh.source_bank_name,
 			bh.transfer_proof_img_url,
+			bh.transfer_reference,
+			bh.exchange_rate,
+			bh.journal_entry_id,
+			bh.reversal_of,
+			rev.id AS reversed_by,
+			bh.status,
+			bh.status_reason,
 			bh.created_at
 		FROM
 			balance_histories bh
+			LEFT JOIN balance_histories rev ON rev.reversal_of = bh.id
 		WHERE
 			bh.user_id = ?
 		%s
@@ -71,48 +175,184 @@ func (r *balanceRepo) GetBalanceHistory(ctx context.Context, payload GetBalanceH
 	args = append(args, filterArgs...)
 
 	queryWithFilter := fmt.Sprintf(baseQuery, filterQuery)
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS temp", queryWithFilter)
 
-	var count uint
-	err := r.db.GetContext(ctx, &count, sqlx.Rebind(sqlx.DOLLAR, countQuery), args...)
-	if err != nil {
-		return balanceHistories, count, err
+	if !payload.SkipTotal {
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS temp", queryWithFilter)
+
+		var count uint
+		err := r.db.GetContext(ctx, &count, sqlx.Rebind(sqlx.DOLLAR, countQuery), args...)
+		if err != nil {
+			return page, err
+		}
+		page.Total = &count
 	}
 
+	cursorQuery, cursorArgs := getCursorFilter(payload)
+	args = append(args, cursorArgs...)
+
 	orderQuery := getSortBy(payload)
 	limitQuery, limitArgs := getLimitAndOffset(payload)
 	args = append(args, limitArgs...)
 
-	query := fmt.Sprintf("%s %s %s", queryWithFilter, orderQuery, limitQuery)
+	query := fmt.Sprintf("%s %s %s %s", queryWithFilter, cursorQuery, orderQuery, limitQuery)
 
-	err = r.db.SelectContext(ctx, &balanceHistories, sqlx.Rebind(sqlx.DOLLAR, query), args...)
+	var balanceHistories []BalanceHistory
+	err := r.db.SelectContext(ctx, &balanceHistories, sqlx.Rebind(sqlx.DOLLAR, query), args...)
 	if err != nil {
-		return balanceHistories, count, err
+		return page, err
+	}
+
+	// one more row than the limit is fetched to know whether there's a further page
+	limit := getLimit(payload)
+	hasMore := len(balanceHistories) > int(limit)
+	if hasMore {
+		balanceHistories = balanceHistories[:limit]
+	}
+
+	backward := payload.cursor != nil && payload.cursor.Backward
+	if backward {
+		// backward pages are fetched in the reverse order
+		for i, j := 0, len(balanceHistories)-1; i < j; i, j = i+1, j-1 {
+			balanceHistories[i], balanceHistories[j] = balanceHistories[j], balanceHistories[i]
+		}
+	}
+	page.Histories = balanceHistories
+
+	// cursors only make sense when paginating in the created_at order
+	if len(balanceHistories) == 0 || (payload.SortBy != "" && payload.SortBy != SortByCreatedAt) {
+		return page, nil
 	}
 
-	return balanceHistories, count, nil
+	// going backward, there's always a next page: the one the cursor came from
+	hasNext, hasPrev := hasMore, payload.cursor != nil || payload.Offset > 0
+	if backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	if hasNext {
+		page.NextCursor = newHistoryCursor(balanceHistories[len(balanceHistories)-1], false).Encode()
+	}
+	if hasPrev {
+		page.PrevCursor = newHistoryCursor(balanceHistories[0], true).Encode()
+	}
+
+	return page, nil
 }
 
-func getFilter(_ GetBalanceHistoryRequest) (string, []interface{}) {
+func getFilter(req GetBalanceHistoryRequest) (string, []interface{}) {
 	args := []interface{}{}
 	filter := ""
+
+	if req.Status != "" {
+		filter += " AND bh.status = ?"
+		args = append(args, req.Status)
+	}
+
+	if req.Currency != "" {
+		filter += " AND bh.currency = ?"
+		args = append(args, req.Currency)
+	}
+
+	switch req.Direction {
+	case DirectionCredit:
+		filter += " AND bh.balance > 0"
+	case DirectionDebit:
+		filter += " AND bh.balance < 0"
+	}
+
+	if req.CreatedAtFrom > 0 {
+		filter += " AND bh.created_at >= ?"
+		args = append(args, time.UnixMilli(int64(req.CreatedAtFrom)).UTC())
+	}
+
+	if req.CreatedAtTo > 0 {
+		filter += " AND bh.created_at <= ?"
+		args = append(args, time.UnixMilli(int64(req.CreatedAtTo)).UTC())
+	}
+
+	// amount is filtered regardless of the direction
+	if req.minAmount != nil {
+		filter += " AND ABS(bh.balance) >= ?"
+		args = append(args, req.minAmount.Amount)
+	}
+
+	if req.maxAmount != nil {
+		filter += " AND ABS(bh.balance) <= ?"
+		args = append(args, req.maxAmount.Amount)
+	}
+
+	if req.BankName != "" {
+		filter += " AND bh.source_bank_name = ?"
+		args = append(args, req.BankName)
+	}
+
+	if req.BankAccountNumber != "" {
+		filter += " AND bh.source_bank_account_number = ?"
+		args = append(args, req.BankAccountNumber)
+	}
+
 	return filter, args
 }
 
-func getSortBy(_ GetBalanceHistoryRequest) string {
-	// hardcoded for now
-	return `ORDER BY bh.created_at DESC`
+var sortByColumns = map[string]string{
+	SortByCreatedAt: "bh.created_at",
+	SortByBalance:   "bh.balance",
 }
 
-func getLimitAndOffset(req GetBalanceHistoryRequest) (string, []interface{}) {
-	// by default, set limit to 50
-	query := "LIMIT ? OFFSET ?"
+func getSortBy(req GetBalanceHistoryRequest) string {
+	// only whitelisted columns & orders end up in the query, by default sort by the newest
+	column, ok := sortByColumns[req.SortBy]
+	if !ok {
+		column = sortByColumns[SortByCreatedAt]
+	}
+
+	desc := req.SortOrder != SortOrderAsc
+	if req.cursor != nil && req.cursor.Backward {
+		// walk away from the cursor, the rows are put back in order after being fetched
+		desc = !desc
+	}
+
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+
+	// id is used as tiebreaker so that the order is stable between pages
+	return fmt.Sprintf("ORDER BY %s %s, bh.id %s", column, order, order)
+}
+
+func getCursorFilter(req GetBalanceHistoryRequest) (string, []interface{}) {
+	if req.cursor == nil {
+		return "", []interface{}{}
+	}
+
+	// rows after the cursor come later in the requested order, rows before it come earlier
+	operator := "<"
+	if (req.SortOrder == SortOrderAsc) != req.cursor.Backward {
+		operator = ">"
+	}
 
+	query := fmt.Sprintf("AND (bh.created_at, bh.id) %s (?, ?)", operator)
+	args := []interface{}{req.cursor.CreatedAt, req.cursor.ID}
+
+	return query, args
+}
+
+func getLimit(req GetBalanceHistoryRequest) uint {
 	limit := req.Limit
 	if limit <= 0 {
 		limit = 10
 	}
 
+	return limit
+}
+
+func getLimitAndOffset(req GetBalanceHistoryRequest) (string, []interface{}) {
+	query := "LIMIT ? OFFSET ?"
+
+	// fetch one more row to find out if there's a next page
+	limit := getLimit(req) + 1
+
 	// offset by default will be 0
 	offset := req.Offset
 
@@ -126,24 +366,35 @@ func (r *balanceRepo) GetBalancePerCurrencies(ctx context.Context, userID, curre
 
 	baseQuery := `
 		SELECT
-			currency,
-			COALESCE(SUM(balance), 0) AS balance_per_currency
+			ub.currency,
+			ub.amount AS balance_per_currency,
+			COALESCE(bh.held_amount, 0) AS held_amount
 		FROM
-			balance_histories
+			user_balances ub
+			LEFT JOIN (
+				SELECT
+					currency,
+					SUM(amount) AS held_amount
+				FROM
+					balance_holds
+				WHERE
+					user_id = ?
+					AND released_at IS NULL
+				GROUP BY
+					currency
+			) bh ON bh.currency = ub.currency
 		WHERE
-			user_id = ?
+			ub.user_id = ?
 			%s
-		GROUP BY
-			currency
 		ORDER BY
 			balance_per_currency DESC
 	`
 
-	args := []interface{}{userID}
+	args := []interface{}{userID, userID}
 
 	var additionalWhere string
 	if currency != "" {
-		additionalWhere = " AND currency = ?"
+		additionalWhere = " AND ub.currency = ?"
 		args = append(args, currency)
 	}
 
@@ -156,3 +407,588 @@ func (r *balanceRepo) GetBalancePerCurrencies(ctx context.Context, userID, curre
 
 	return balancePerCurrency, nil
 }
+
+// GetCurrencyBalanceForUpdate returns the user's balance in a currency and locks its row until tx ends,
+// so concurrent check-and-debits on the same user & currency are serialized.
+func (r *balanceRepo) GetCurrencyBalanceForUpdate(ctx context.Context, tx *sql.Tx, userID, currency string) (int64, error) {
+	var balance int64
+
+	query := `
+		SELECT
+			amount
+		FROM
+			user_balances
+		WHERE
+			user_id = $1
+			AND currency = $2
+		FOR UPDATE
+	`
+
+	err := tx.QueryRowContext(ctx, query, userID, currency).Scan(&balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// no balance has ever been added in this currency
+			return 0, nil
+		}
+
+		return balance, err
+	}
+
+	return balance, nil
+}
+
+// GetHeldAmount sums the active holds of the user's balance in a currency. Holds are only created
+// while the balance is locked, so the sum is consistent with a balance read by GetCurrencyBalanceForUpdate.
+func (r *balanceRepo) GetHeldAmount(ctx context.Context, tx *sql.Tx, userID, currency string) (int64, error) {
+	var held int64
+
+	query := `
+		SELECT
+			COALESCE(SUM(amount), 0)
+		FROM
+			balance_holds
+		WHERE
+			user_id = $1
+			AND currency = $2
+			AND released_at IS NULL
+	`
+
+	err := tx.QueryRowContext(ctx, query, userID, currency).Scan(&held)
+	if err != nil {
+		return held, err
+	}
+
+	return held, nil
+}
+
+func (r *balanceRepo) CreateHold(ctx context.Context, tx *sql.Tx, hold Hold) error {
+	query := `
+		INSERT INTO balance_holds
+			(id, user_id, currency, amount, reason, created_by)
+		VALUES
+			(:id, :user_id, :currency, :amount, :reason, :created_by)
+	`
+
+	updatedQuery, args, err := sqlx.Named(query, hold)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, updatedQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetHoldForUpdate returns the user's hold, and locks it until tx ends
+func (r *balanceRepo) GetHoldForUpdate(ctx context.Context, tx *sql.Tx, id, userID string) (Hold, error) {
+	var result Hold
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			currency,
+			amount,
+			reason,
+			created_by,
+			released_by,
+			released_at,
+			created_at
+		FROM
+			balance_holds
+		WHERE
+			id = $1
+			AND user_id = $2
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, id, userID)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ReleaseHold makes the held amount available again, and returns the released hold
+func (r *balanceRepo) ReleaseHold(ctx context.Context, tx *sql.Tx, id, releasedBy string) (Hold, error) {
+	var result Hold
+
+	query := `
+		UPDATE
+			balance_holds
+		SET
+			released_by = $2,
+			released_at = NOW()
+		WHERE
+			id = $1
+		RETURNING
+			id,
+			user_id,
+			currency,
+			amount,
+			reason,
+			created_by,
+			released_by,
+			released_at,
+			created_at
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, id, releasedBy)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetHolds returns the user's holds, newest first. Released holds are only included if asked.
+func (r *balanceRepo) GetHolds(ctx context.Context, payload GetHoldsRequest) ([]Hold, error) {
+	var result []Hold
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			currency,
+			amount,
+			reason,
+			created_by,
+			released_by,
+			released_at,
+			created_at
+		FROM
+			balance_holds
+		WHERE
+			user_id = $1
+			AND ($2 OR released_at IS NULL)
+		ORDER BY
+			created_at DESC, id ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	err := r.db.SelectContext(ctx, &result, query, payload.UserID, payload.IncludeReleased, limit, payload.Offset)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// LockOutboundLimits serializes the limit checks of the user until tx ends, across every currency
+func (r *balanceRepo) LockOutboundLimits(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetOutboundUsage sums what the user has withdrawn and transferred out in the currency since the start of
+// the day and the month, and counts the outgoing transactions in any currency within the last hour
+func (r *balanceRepo) GetOutboundUsage(ctx context.Context, tx *sql.Tx, userID, currency string) (OutboundUsage, error) {
+	var result OutboundUsage
+
+	query := `
+		SELECT
+			COALESCE(SUM(-bh.balance) FILTER (
+				WHERE bh.currency = $2 AND bh.created_at >= date_trunc('day', NOW())
+			), 0) AS daily_amount,
+			COALESCE(SUM(-bh.balance) FILTER (
+				WHERE bh.currency = $2 AND bh.created_at >= date_trunc('month', NOW())
+			), 0) AS monthly_amount,
+			COUNT(*) FILTER (
+				WHERE bh.created_at > NOW() - INTERVAL '1 hour'
+			) AS hourly_count
+		FROM
+			balance_histories bh
+			JOIN journal_entries je ON je.id = bh.journal_entry_id
+		WHERE
+			bh.user_id = $1
+			AND bh.balance < 0
+			AND je.kind IN ($3, $4)
+			AND bh.created_at >= LEAST(date_trunc('month', NOW()), NOW() - INTERVAL '1 hour')
+			-- reversed transactions gave the money back, so they don't count
+			AND NOT EXISTS (SELECT 1 FROM journal_entries rev WHERE rev.reversal_of = je.id)
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, userID, currency, JournalKindWithdrawal, JournalKindTransfer)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// HasOpenBalance checks whether the user has a non-zero balance in any currency, or a top-up waiting for review.
+// The balances are locked until tx ends, so no money can move in or out while the account is being closed.
+func (r *balanceRepo) HasOpenBalance(ctx context.Context, tx *sql.Tx, userID string) (bool, error) {
+	var amounts []int64
+
+	query := `
+		SELECT
+			amount
+		FROM
+			user_balances
+		WHERE
+			user_id = $1
+		FOR UPDATE
+	`
+
+	err := sqlx.SelectContext(ctx, r.txx(tx), &amounts, query, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, amount := range amounts {
+		if amount != 0 {
+			return true, nil
+		}
+	}
+
+	var hasPendingTopUp bool
+
+	query = `
+		SELECT EXISTS (
+			SELECT 1 FROM balance_histories WHERE user_id = $1 AND status = $2
+		)
+	`
+
+	err = tx.QueryRowContext(ctx, query, userID, StatusPending).Scan(&hasPendingTopUp)
+	if err != nil {
+		return false, err
+	}
+
+	return hasPendingTopUp, nil
+}
+
+// GetBalanceMismatches compares the materialized balances against the sum of the ledger postings,
+// and returns every user & currency pair where both differ.
+func (r *balanceRepo) GetBalanceMismatches(ctx context.Context) ([]BalanceMismatch, error) {
+	var mismatches []BalanceMismatch
+
+	query := `
+		SELECT
+			COALESCE(ub.user_id, l.user_id) AS user_id,
+			COALESCE(ub.currency, l.currency) AS currency,
+			COALESCE(ub.amount, 0) AS balance_amount,
+			COALESCE(l.ledger_amount, 0) AS ledger_amount
+		FROM
+			user_balances ub
+		FULL OUTER JOIN (
+			SELECT
+				la.user_id,
+				p.currency,
+				SUM(p.amount) AS ledger_amount
+			FROM
+				postings p
+				JOIN ledger_accounts la ON la.id = p.account_id
+			WHERE
+				la.user_id IS NOT NULL
+			GROUP BY
+				la.user_id, p.currency
+		) l ON l.user_id = ub.user_id AND l.currency = ub.currency
+		WHERE
+			COALESCE(ub.amount, 0) <> COALESCE(l.ledger_amount, 0)
+		ORDER BY
+			user_id, currency
+	`
+
+	err := r.db.SelectContext(ctx, &mismatches, query)
+	if err != nil {
+		return mismatches, err
+	}
+
+	return mismatches, nil
+}
+
+// GetUnbalancedJournalEntries returns every journal entry & currency pair whose postings don't sum to zero
+func (r *balanceRepo) GetUnbalancedJournalEntries(ctx context.Context) ([]UnbalancedJournalEntry, error) {
+	var entries []UnbalancedJournalEntry
+
+	query := `
+		SELECT
+			journal_entry_id,
+			currency,
+			SUM(amount) AS total_amount
+		FROM
+			postings
+		GROUP BY
+			journal_entry_id, currency
+		HAVING
+			SUM(amount) <> 0
+		ORDER BY
+			journal_entry_id, currency
+	`
+
+	err := r.db.SelectContext(ctx, &entries, query)
+	if err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+func (r *balanceRepo) CreateExchangeQuote(ctx context.Context, quote ExchangeQuote, ttl time.Duration) (ExchangeQuote, error) {
+	query := `
+		INSERT INTO exchange_quotes
+			(id, user_id, from_currency, to_currency, rate, from_amount, to_amount, expires_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, NOW() + make_interval(secs => $8))
+		RETURNING
+			expires_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx, query,
+		quote.ID, quote.UserID, quote.FromCurrency, quote.ToCurrency,
+		quote.Rate, quote.FromAmount, quote.ToAmount, ttl.Seconds(),
+	).Scan(&quote.ExpiresAt)
+	if err != nil {
+		return quote, err
+	}
+
+	return quote, nil
+}
+
+// GetExchangeQuoteForUpdate returns the user's quote and locks it until tx ends,
+// so the same quote can't be executed twice
+func (r *balanceRepo) GetExchangeQuoteForUpdate(ctx context.Context, tx *sql.Tx, id, userID string) (ExchangeQuote, error) {
+	var result ExchangeQuote
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			from_currency,
+			to_currency,
+			rate,
+			from_amount,
+			to_amount,
+			expires_at,
+			executed_at,
+			expires_at < NOW() AS expired
+		FROM
+			exchange_quotes
+		WHERE
+			id = $1
+			AND user_id = $2
+		FOR UPDATE
+	`
+
+	err := tx.QueryRowContext(ctx, query, id, userID).Scan(
+		&result.ID, &result.UserID, &result.FromCurrency, &result.ToCurrency,
+		&result.Rate, &result.FromAmount, &result.ToAmount,
+		&result.ExpiresAt, &result.ExecutedAt, &result.Expired,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *balanceRepo) MarkExchangeQuoteExecuted(ctx context.Context, tx *sql.Tx, id string) error {
+	query := `
+		UPDATE
+			exchange_quotes
+		SET
+			executed_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetBalanceHistoryForUpdate returns the history and locks it until tx ends
+func (r *balanceRepo) GetBalanceHistoryForUpdate(ctx context.Context, tx *sql.Tx, id string) (BalanceHistory, error) {
+	var result BalanceHistory
+
+	query := `
+		SELECT
+			bh.id,
+			bh.user_id,
+			bh.currency,
+			bh.balance,
+			bh.source_bank_account_number,
+			bh.source_bank_name,
+			bh.transfer_proof_img_url,
+			bh.transfer_reference,
+			bh.exchange_rate,
+			bh.journal_entry_id,
+			bh.reversal_of,
+			bh.status,
+			bh.status_reason,
+			bh.created_at
+		FROM
+			balance_histories bh
+		WHERE
+			bh.id = $1
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetJournalEntryForUpdate returns the entry with its postings, and locks it until tx ends
+// so it can't be reversed concurrently
+func (r *balanceRepo) GetJournalEntryForUpdate(ctx context.Context, tx *sql.Tx, id string) (JournalEntry, error) {
+	var result JournalEntry
+
+	entryQuery := `
+		SELECT
+			je.id,
+			je.kind,
+			COALESCE(je.reversal_of, '') AS reversal_of,
+			COALESCE((SELECT rev.id FROM journal_entries rev WHERE rev.reversal_of = je.id), '') AS reversed_by
+		FROM
+			journal_entries je
+		WHERE
+			je.id = $1
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, entryQuery, id)
+	if err != nil {
+		return result, err
+	}
+
+	postingsQuery := `
+		SELECT
+			p.account_id,
+			p.currency,
+			p.amount,
+			COALESCE(la.user_id, '') AS user_id
+		FROM
+			postings p
+			JOIN ledger_accounts la ON la.id = p.account_id
+		WHERE
+			p.journal_entry_id = $1
+		ORDER BY
+			p.id
+	`
+
+	err = sqlx.SelectContext(ctx, r.txx(tx), &result.Postings, postingsQuery, id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *balanceRepo) GetBalanceHistoriesByJournalEntry(ctx context.Context, tx *sql.Tx, journalEntryID string) ([]BalanceHistory, error) {
+	var result []BalanceHistory
+
+	query := `
+		SELECT
+			bh.id,
+			bh.user_id,
+			bh.currency,
+			bh.balance,
+			bh.source_bank_account_number,
+			bh.source_bank_name,
+			bh.transfer_proof_img_url,
+			bh.transfer_reference,
+			bh.exchange_rate,
+			bh.journal_entry_id,
+			bh.reversal_of,
+			bh.status,
+			bh.status_reason,
+			bh.created_at
+		FROM
+			balance_histories bh
+		WHERE
+			bh.journal_entry_id = $1
+		ORDER BY
+			bh.balance ASC, bh.id ASC
+	`
+
+	err := sqlx.SelectContext(ctx, r.txx(tx), &result, query, journalEntryID)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ReviewBalanceHistory saves the reviewer's decision on a pending top-up
+func (r *balanceRepo) ReviewBalanceHistory(ctx context.Context, tx *sql.Tx, val BalanceHistory) error {
+	query := `
+		UPDATE
+			balance_histories
+		SET
+			status = $2,
+			status_reason = $3,
+			journal_entry_id = $4,
+			reviewed_by = $5,
+			reviewed_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, val.ID, val.Status, val.StatusReason, val.JournalEntryID, val.ReviewedBy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *balanceRepo) GetPendingTopUps(ctx context.Context, payload GetPendingTopUpsRequest) ([]BalanceHistory, error) {
+	var result []BalanceHistory
+
+	query := `
+		SELECT
+			bh.id,
+			bh.user_id,
+			bh.currency,
+			bh.balance,
+			bh.source_bank_account_number,
+			bh.source_bank_name,
+			bh.transfer_proof_img_url,
+			bh.status,
+			bh.status_reason,
+			bh.created_at
+		FROM
+			balance_histories bh
+		WHERE
+			bh.status = $1
+		ORDER BY
+			bh.created_at ASC, bh.id ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	err := r.db.SelectContext(ctx, &result, query, StatusPending, limit, payload.Offset)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
@@ -1,5 +1,7 @@
 package balance
 
+import "github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+
 type BalanceSourceResponse struct {
 	BankAccountNumber string `json:"bankAccountNumber"`
 	BankName          string `json:"bankName"`
@@ -7,14 +9,106 @@ type BalanceSourceResponse struct {
 
 type BalanceHistoryResponse struct {
 	TransactionID    string                `json:"transactionId"`
-	Balance          int                   `json:"balance"`
+	Balance          money.Decimal         `json:"balance"`
 	Currency         string                `json:"currency"`
 	TransferProofImg string                `json:"transferProofImg"`
 	CreatedAt        uint64                `json:"createdAt"`
 	Source           BalanceSourceResponse `json:"source"`
+
+	// TransferReference links both sides of an internal transfer or an exchange
+	TransferReference string `json:"transferReference,omitempty"`
+	ExchangeRate      string `json:"exchangeRate,omitempty"`
+
+	Status       string `json:"status"`
+	StatusReason string `json:"statusReason,omitempty"`
+
+	// reversal status: the transaction reversing this one, or the one this transaction reverses
+	ReversedBy string `json:"reversedBy,omitempty"`
+	ReversalOf string `json:"reversalOf,omitempty"`
+}
+
+type ExchangeQuoteResponse struct {
+	QuoteID      string        `json:"quoteId"`
+	FromCurrency string        `json:"fromCurrency"`
+	ToCurrency   string        `json:"toCurrency"`
+	Rate         string        `json:"rate"`
+	FromAmount   money.Decimal `json:"fromAmount"`
+	ToAmount     money.Decimal `json:"toAmount"`
+	ExpiresAt    uint64        `json:"expiresAt"`
+}
+
+type ExchangeResponse struct {
+	QuoteID string                 `json:"quoteId"`
+	Rate    string                 `json:"rate"`
+	Debit   BalanceHistoryResponse `json:"debit"`
+	Credit  BalanceHistoryResponse `json:"credit"`
 }
 
 type CurrencyBalanceResponse struct {
-	Balance  int    `json:"balance"`
-	Currency string `json:"currency"`
+	Balance  money.Decimal `json:"balance"`
+	Currency string        `json:"currency"`
+
+	// AvailableBalance is the balance minus the held amount, which is what can be moved
+	AvailableBalance money.Decimal `json:"availableBalance"`
+	HeldBalance      money.Decimal `json:"heldBalance"`
+}
+
+type HoldResponse struct {
+	HoldID     string        `json:"holdId"`
+	UserID     string        `json:"userId"`
+	Amount     money.Decimal `json:"amount"`
+	Currency   string        `json:"currency"`
+	Reason     string        `json:"reason"`
+	CreatedBy  string        `json:"createdBy"`
+	CreatedAt  uint64        `json:"createdAt"`
+	ReleasedBy string        `json:"releasedBy,omitempty"`
+	ReleasedAt uint64        `json:"releasedAt,omitempty"`
+}
+
+func newBalanceHistoryResponse(val BalanceHistory) BalanceHistoryResponse {
+	// rows which haven't been read back from the database have no creation time yet
+	var createdAt uint64
+	if !val.CreatedAt.IsZero() {
+		createdAt = uint64(val.CreatedAt.UnixMilli())
+	}
+
+	return BalanceHistoryResponse{
+		TransactionID:    val.ID,
+		Balance:          money.New(val.Balance, val.Currency).Decimal(),
+		Currency:         val.Currency,
+		TransferProofImg: val.TransferProofImg,
+		CreatedAt:        createdAt,
+		Source: BalanceSourceResponse{
+			BankAccountNumber: val.SourceBankAccountNumber,
+			BankName:          val.SourceBankName,
+		},
+		TransferReference: val.TransferReference.String,
+		ExchangeRate:      val.ExchangeRate.String,
+		Status:            val.Status,
+		StatusReason:      val.StatusReason.String,
+		ReversedBy:        val.ReversedBy.String,
+		ReversalOf:        val.ReversalOf.String,
+	}
+}
+
+func newHoldResponse(val Hold) HoldResponse {
+	var createdAt, releasedAt uint64
+	if !val.CreatedAt.IsZero() {
+		createdAt = uint64(val.CreatedAt.UnixMilli())
+	}
+	if val.ReleasedAt.Valid {
+		releasedAt = uint64(val.ReleasedAt.Time.UnixMilli())
+	}
+
+	return HoldResponse{
+		HoldID:     val.ID,
+		UserID:     val.UserID,
+		Amount:     money.New(val.Amount, val.Currency).Decimal(),
+		Currency:   val.Currency,
+		Reason:     val.Reason,
+		CreatedBy:  val.CreatedBy,
+		CreatedAt:  createdAt,
+		ReleasedBy: val.ReleasedBy.String,
+		ReleasedAt: releasedAt,
+	}
 }
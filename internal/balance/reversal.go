@@ -0,0 +1,170 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ReverseTransaction undoes a transaction by posting its compensating entry. It's only available for admins.
+func (h *balanceHandler) ReverseTransaction(c *fiber.Ctx) error {
+	var payload ReverseTransactionRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.ReversedBy = claims.UserID
+	payload.IPAddress = c.IP()
+
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	reversalEntities, err := h.reverseTransaction(c.Context(), payload)
+	if err != nil {
+		return err
+	}
+
+	responses := []BalanceHistoryResponse{}
+	for _, balanceEntity := range reversalEntities {
+		responses = append(responses, newBalanceHistoryResponse(balanceEntity))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    responses,
+	})
+}
+
+type userCurrency struct {
+	userID   string
+	currency string
+}
+
+// reverseTransaction reverses the whole journal entry behind the transaction, so for transfers and exchanges
+// every side of it is reversed together
+func (h *balanceHandler) reverseTransaction(ctx context.Context, payload ReverseTransactionRequest) ([]BalanceHistory, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	original, err := h.balanceRepo.GetBalanceHistoryForUpdate(ctx, tx, payload.TransactionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, config.ErrTransactionNotFound
+		}
+
+		return nil, errors.Wrap(err, "GetBalanceHistoryForUpdate error")
+	}
+	if !original.JournalEntryID.Valid {
+		return nil, config.ErrNotReversible
+	}
+
+	entry, err := h.balanceRepo.GetJournalEntryForUpdate(ctx, tx, original.JournalEntryID.String)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetJournalEntryForUpdate error")
+	}
+	if entry.Kind == JournalKindReversal {
+		return nil, config.ErrNotReversible
+	}
+	if entry.ReversedBy != "" {
+		return nil, config.ErrAlreadyReversed
+	}
+
+	reversal := entry.Reverse(uuid.NewString(), payload.ReversedBy)
+
+	// reversing a credit takes the money back, which must not overdraw any of the affected balances,
+	// nor take the money a hold is keeping
+	changes := map[userCurrency]int64{}
+	for _, posting := range reversal.Postings {
+		if posting.UserID != "" {
+			changes[userCurrency{posting.UserID, posting.Currency}] += posting.Amount
+		}
+	}
+
+	// lock the balances in a fixed order, so concurrent movements can't deadlock
+	keys := []userCurrency{}
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].userID != keys[j].userID {
+			return keys[i].userID < keys[j].userID
+		}
+		return keys[i].currency < keys[j].currency
+	})
+
+	for _, key := range keys {
+		available, err := h.getAvailableBalanceForUpdate(ctx, tx, key.userID, key.currency)
+		if err != nil {
+			return nil, err
+		}
+		if changes[key] < 0 && available+changes[key] < 0 {
+			return nil, config.ErrReversalOverdraws
+		}
+	}
+
+	histories, err := h.balanceRepo.GetBalanceHistoriesByJournalEntry(ctx, tx, entry.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetBalanceHistoriesByJournalEntry error")
+	}
+
+	reversalEntities := []BalanceHistory{}
+	for _, history := range histories {
+		reversalEntities = append(reversalEntities, BalanceHistory{
+			ID:                      uuid.NewString(),
+			UserID:                  history.UserID,
+			Currency:                history.Currency,
+			Balance:                 history.Balance * -1,
+			SourceBankAccountNumber: history.SourceBankAccountNumber,
+			SourceBankName:          history.SourceBankName,
+			Status:                  StatusCompleted,
+			TransferProofImg:        history.TransferProofImg,
+			TransferReference:       history.TransferReference,
+			ExchangeRate:            history.ExchangeRate,
+			ReversalOf:              sql.NullString{String: history.ID, Valid: true},
+		})
+	}
+
+	err = h.balanceRepo.PostJournalEntry(ctx, tx, reversal, reversalEntities...)
+	if err != nil {
+		return nil, errors.Wrap(err, "PostJournalEntry error")
+	}
+
+	// a transfer reverses the balances of both of its users, so both of them get a log
+	loggedUsers := map[string]bool{}
+	for _, reversalEntity := range reversalEntities {
+		if loggedUsers[reversalEntity.UserID] {
+			continue
+		}
+		loggedUsers[reversalEntity.UserID] = true
+
+		err = h.logStaffAction(ctx, tx, payload.ReversedBy, payload.IPAddress, user.AuditEventTransactionReversed,
+			reversalEntity.UserID, "reversed transaction "+payload.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Commit error")
+	}
+
+	return reversalEntities, nil
+}
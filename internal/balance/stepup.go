@@ -0,0 +1,102 @@
+package balance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// StepUpCodeHeader carries the TOTP code of a transaction which needs step-up verification
+const StepUpCodeHeader = "X-MFA-Code"
+
+// StepUpThresholds are the amounts per currency, in minor units, from which a transaction
+// has to be verified with a TOTP code. Currencies without a threshold never need it.
+type StepUpThresholds map[string]int64
+
+// ParseStepUpThresholds parses the thresholds written like USD=1000,IDR=15000000 in the major unit of each currency
+func ParseStepUpThresholds(value string) (StepUpThresholds, error) {
+	thresholds := StepUpThresholds{}
+	if strings.TrimSpace(value) == "" {
+		return thresholds, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		currency, amount, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("step-up threshold %q is not written as CURRENCY=AMOUNT", pair)
+		}
+
+		threshold, err := money.Parse(amount, strings.ToUpper(currency))
+		if err != nil {
+			return nil, fmt.Errorf("step-up threshold %q: %s", pair, err.Error())
+		}
+
+		thresholds[threshold.Currency] = threshold.Amount
+	}
+
+	return thresholds, nil
+}
+
+// verifyStepUp checks the TOTP code of the request if the amount reaches the threshold of its currency.
+// Wrong codes are throttled per user, like failed logins.
+func (h *balanceHandler) verifyStepUp(c *fiber.Ctx, userID string, amount money.Money) error {
+	threshold, ok := h.stepUpThresholds[amount.Currency]
+	if !ok || amount.Amount < threshold {
+		return nil
+	}
+
+	ctx := c.Context()
+	enabled, err := h.totpVerifier.IsEnabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return config.ErrMFANotEnabled
+	}
+
+	code := c.Get(StepUpCodeHeader)
+	if code == "" {
+		return config.ErrStepUpRequired
+	}
+
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	if err := h.stepUpThrottler.CheckStepUp(ctx, tx, userID); err != nil {
+		var throttledErr *user.ThrottledLoginError
+		if errors.As(err, &throttledErr) {
+			c.Set(fiber.HeaderRetryAfter, throttledErr.RetryAfterSeconds())
+		}
+
+		return err
+	}
+
+	err = h.totpVerifier.VerifyCode(ctx, userID, code)
+	if err != nil {
+		if err == config.ErrInvalidMFACode {
+			if err := h.stepUpThrottler.RecordStepUpFailure(ctx, tx, userID, c.IP()); err != nil {
+				return errors.Wrap(err, "RecordStepUpFailure error")
+			}
+
+			if err := tx.Commit(); err != nil {
+				return errors.Wrap(err, "Commit error")
+			}
+		}
+
+		return err
+	}
+
+	if err := h.stepUpThrottler.RecordStepUpSuccess(ctx, tx, userID); err != nil {
+		return errors.Wrap(err, "RecordStepUpSuccess error")
+	}
+
+	return tx.Commit()
+}
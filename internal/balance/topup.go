@@ -0,0 +1,149 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// GetPendingTopUps lists the top-ups waiting for review, oldest first. It's only available for support staff and admins.
+func (h *balanceHandler) GetPendingTopUps(c *fiber.Ctx) error {
+	var payload GetPendingTopUpsRequest
+	if err := c.QueryParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	ctx := c.Context()
+	topUps, err := h.balanceRepo.GetPendingTopUps(ctx, payload)
+	if err != nil {
+		return errors.Wrap(err, "GetPendingTopUps error")
+	}
+
+	responses := []BalanceHistoryResponse{}
+	for _, balanceEntity := range topUps {
+		responses = append(responses, newBalanceHistoryResponse(balanceEntity))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    responses,
+		Meta: &model.ResponseMeta{
+			Limit:  payload.Limit,
+			Offset: payload.Offset,
+		},
+	})
+}
+
+// ApproveTopUp credits a pending top-up to the user's balance. It's only available for support staff and admins.
+func (h *balanceHandler) ApproveTopUp(c *fiber.Ctx) error {
+	return h.handleTopUpReview(c, StatusApproved)
+}
+
+// RejectTopUp closes a pending top-up without crediting it. It's only available for support staff and admins.
+func (h *balanceHandler) RejectTopUp(c *fiber.Ctx) error {
+	return h.handleTopUpReview(c, StatusRejected)
+}
+
+func (h *balanceHandler) handleTopUpReview(c *fiber.Ctx, status string) error {
+	var payload ReviewTopUpRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.ReviewedBy = claims.UserID
+	payload.IPAddress = c.IP()
+
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return errors.Wrap(config.ErrMalformedRequest, err.Error())
+		}
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	// the user has to know why the top-up is not credited
+	if status == StatusRejected && payload.Reason == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "reason is required")
+	}
+
+	balanceEntity, err := h.reviewTopUp(c.Context(), payload, status)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newBalanceHistoryResponse(balanceEntity),
+	})
+}
+
+func (h *balanceHandler) reviewTopUp(ctx context.Context, payload ReviewTopUpRequest, status string) (BalanceHistory, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// locking the top-up makes sure it's only reviewed, and credited, once
+	balanceEntity, err := h.balanceRepo.GetBalanceHistoryForUpdate(ctx, tx, payload.TransactionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return BalanceHistory{}, config.ErrTransactionNotFound
+		}
+
+		return BalanceHistory{}, errors.Wrap(err, "GetBalanceHistoryForUpdate error")
+	}
+	if balanceEntity.Status != StatusPending {
+		return BalanceHistory{}, config.ErrTopUpNotPending
+	}
+
+	balanceEntity.Status = status
+	balanceEntity.StatusReason = sql.NullString{String: payload.Reason, Valid: payload.Reason != ""}
+	balanceEntity.ReviewedBy = sql.NullString{String: payload.ReviewedBy, Valid: true}
+
+	if status == StatusApproved {
+		// the history already exists, so only the entry and its postings are recorded
+		entry := newJournalEntry(balanceEntity.ID, JournalKindTopUp, SystemAccountExternalInflow, balanceEntity)
+		err = h.balanceRepo.PostJournalEntry(ctx, tx, entry)
+		if err != nil {
+			return BalanceHistory{}, errors.Wrap(err, "PostJournalEntry error")
+		}
+		balanceEntity.JournalEntryID = sql.NullString{String: entry.ID, Valid: true}
+	}
+
+	err = h.balanceRepo.ReviewBalanceHistory(ctx, tx, balanceEntity)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "ReviewBalanceHistory error")
+	}
+
+	event, detail := user.AuditEventTopUpApproved, "approved top-up "+balanceEntity.ID
+	if status == StatusRejected {
+		event, detail = user.AuditEventTopUpRejected, fmt.Sprintf("rejected top-up %s: %s", balanceEntity.ID, payload.Reason)
+	}
+
+	err = h.logStaffAction(ctx, tx, payload.ReviewedBy, payload.IPAddress, event, balanceEntity.UserID, detail)
+	if err != nil {
+		return BalanceHistory{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "Commit error")
+	}
+
+	return balanceEntity, nil
+}
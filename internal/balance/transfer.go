@@ -0,0 +1,157 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/money"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+func (h *balanceHandler) CreateTransfer(c *fiber.Ctx) error {
+	var payload CreateTransferRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	amount, err := parseAmount(payload.Amount, payload.Currency)
+	if err != nil {
+		return err
+	}
+
+	// large amounts going to another user need the second factor, like withdrawals do
+	err = h.verifyStepUp(c, payload.UserID, amount)
+	if err != nil {
+		return err
+	}
+
+	balanceEntity, err := h.createTransfer(c.Context(), payload, amount)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newBalanceHistoryResponse(balanceEntity),
+	})
+}
+
+// createTransfer moves the balance to another PaimonBank user, recording a debit for the sender
+// and a credit for the recipient linked by the same transfer reference
+func (h *balanceHandler) createTransfer(ctx context.Context, payload CreateTransferRequest, amount money.Money) (BalanceHistory, error) {
+	recipient, err := h.getRecipient(ctx, payload)
+	if err != nil {
+		return BalanceHistory{}, err
+	}
+	if recipient.ID == payload.UserID {
+		return BalanceHistory{}, config.ErrSelfTransfer
+	}
+
+	normalizedCurrency := amount.Currency
+
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// lock both balances in a fixed order, so opposite transfers between the same users can't deadlock
+	lockedBalances := map[string]int64{}
+	userIDs := []string{payload.UserID, recipient.ID}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		balance, err := h.balanceRepo.GetCurrencyBalanceForUpdate(ctx, tx, userID, normalizedCurrency)
+		if err != nil {
+			return BalanceHistory{}, errors.Wrap(err, "GetCurrencyBalanceForUpdate error")
+		}
+		lockedBalances[userID] = balance
+	}
+
+	// the held amount can't be transferred
+	held, err := h.getHeldAmount(ctx, tx, payload.UserID, normalizedCurrency)
+	if err != nil {
+		return BalanceHistory{}, err
+	}
+	if lockedBalances[payload.UserID]-held < amount.Amount {
+		return BalanceHistory{}, config.ErrInsufficientBalance
+	}
+
+	err = h.checkLimits(ctx, tx, payload.UserID, amount)
+	if err != nil {
+		return BalanceHistory{}, err
+	}
+
+	transferReference := sql.NullString{String: uuid.NewString(), Valid: true}
+	debitEntity := BalanceHistory{
+		ID:                      uuid.NewString(),
+		UserID:                  payload.UserID,
+		Currency:                normalizedCurrency,
+		Balance:                 amount.Amount * -1,
+		SourceBankAccountNumber: recipient.ID,
+		SourceBankName:          InternalBankName,
+		Status:                  StatusCompleted,
+		TransferReference:       transferReference,
+	}
+	creditEntity := BalanceHistory{
+		ID:                      uuid.NewString(),
+		UserID:                  recipient.ID,
+		Currency:                normalizedCurrency,
+		Balance:                 amount.Amount,
+		SourceBankAccountNumber: payload.UserID,
+		SourceBankName:          InternalBankName,
+		Status:                  StatusCompleted,
+		TransferReference:       transferReference,
+	}
+
+	// both sides offset each other, no system account is involved
+	entry := newJournalEntry(transferReference.String, JournalKindTransfer, "", debitEntity, creditEntity)
+	err = h.balanceRepo.PostJournalEntry(ctx, tx, entry, debitEntity, creditEntity)
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "PostJournalEntry error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return BalanceHistory{}, errors.Wrap(err, "Commit error")
+	}
+
+	return debitEntity, nil
+}
+
+func (h *balanceHandler) getRecipient(ctx context.Context, payload CreateTransferRequest) (user.User, error) {
+	var recipient user.User
+	var err error
+
+	if payload.RecipientUserID != "" {
+		recipient, err = h.userRepo.GetUserByID(ctx, payload.RecipientUserID)
+	} else {
+		recipient, err = h.userRepo.GetUserByEmail(ctx, payload.RecipientEmail)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return recipient, config.ErrUserNotFound
+		}
+
+		return recipient, errors.Wrap(err, "get recipient error")
+	}
+
+	return recipient, nil
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/joeshaw/envdecode"
+import (
+	"time"
+
+	"github.com/joeshaw/envdecode"
+)
 
 type DatabaseConfig struct {
 	Name              string `env:"DB_NAME"`
@@ -22,6 +26,21 @@ type S3Config struct {
 	Region    string `env:"S3_REGION"`
 }
 
+type LocalStorageConfig struct {
+	Dir     string `env:"LOCAL_STORAGE_DIR,default=uploads"`
+	BaseURL string `env:"LOCAL_STORAGE_BASE_URL,default=http://localhost:8080"`
+	// SigningKey signs the download URLs of the stored files
+	SigningKey string `env:"LOCAL_STORAGE_SIGNING_KEY"`
+}
+
+type LoginThrottleConfig struct {
+	MaxAccountFailures int           `env:"LOGIN_MAX_ACCOUNT_FAILURES,default=5"`
+	MaxIPFailures      int           `env:"LOGIN_MAX_IP_FAILURES,default=20"`
+	BaseDelay          time.Duration `env:"LOGIN_BASE_DELAY,default=1s"`
+	FailureWindow      time.Duration `env:"LOGIN_FAILURE_WINDOW,default=1h"`
+	LockoutDuration    time.Duration `env:"LOGIN_LOCKOUT_DURATION,default=15m"`
+}
+
 type Config struct {
 	Database          DatabaseConfig
 	AppPort           string `env:"APP_PORT,default=8080"`
@@ -29,14 +48,57 @@ type Config struct {
 	Env               string `env:"ENV"`
 
 	// security-related options
-	JWTSecret  string `env:"JWT_SECRET"`
-	BcryptSalt int    `env:"BCRYPT_SALT"`
+	// JWTSecret is the base64 encoded HS256 secret, used when JWTKeysFile is not set
+	JWTSecret string `env:"JWT_SECRET"`
+	// JWTKeysFile lists the signing keys, e.g. for RS256/EdDSA signing with key rotation
+	JWTKeysFile string `env:"JWT_KEYS_FILE"`
+	BcryptSalt  int    `env:"BCRYPT_SALT"`
+
+	// AccessTokenTTL is how long an access token is valid. It's kept short, since it's renewed with the refresh token.
+	AccessTokenTTL time.Duration `env:"ACCESS_TOKEN_TTL,default=15m"`
+	// RefreshTokenTTL is how long a refresh token is valid, after which the user has to log in again
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL,default=720h"`
+
+	// PasswordResetTokenTTL is how long a password reset token can be used
+	PasswordResetTokenTTL time.Duration `env:"PASSWORD_RESET_TOKEN_TTL,default=30m"`
+	// EmailVerificationTokenTTL is how long the token sent to verify an email can be used
+	EmailVerificationTokenTTL time.Duration `env:"EMAIL_VERIFICATION_TOKEN_TTL,default=24h"`
+
+	// MFAChallengeTTL is how long the second step of a login with MFA can be completed
+	MFAChallengeTTL time.Duration `env:"MFA_CHALLENGE_TTL,default=5m"`
+	// StepUpThresholds are the amounts per currency from which an outgoing transaction needs
+	// a TOTP code, written like USD=1000,IDR=15000000
+	StepUpThresholds string `env:"STEP_UP_THRESHOLDS"`
 
-	// S3Enabled is a flag which if set to true, will set image upload to s3
+	// NotifierFile is where the notifications are written to as JSON lines. If empty, they're logged instead.
+	NotifierFile string `env:"NOTIFIER_FILE"`
+
+	// LoginThrottle slows down and locks out repeated failed logins
+	LoginThrottle LoginThrottleConfig
+
+	// IdempotencyKeyTTL is how long a response can be replayed for the same Idempotency-Key
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL,default=24h"`
+
+	// ExchangeRatesFile is the JSON file of the static exchange rates
+	ExchangeRatesFile string `env:"EXCHANGE_RATES_FILE"`
+	// ExchangeQuoteTTL is how long an exchange quote can be executed after it's given
+	ExchangeQuoteTTL time.Duration `env:"EXCHANGE_QUOTE_TTL,default=1m"`
+
+	// LimitsFile is the JSON file of the outgoing transaction limits per user tier. Without it, there are no limits.
+	LimitsFile string `env:"LIMITS_FILE"`
+
+	// S3Enabled is a flag which if set to true, will set image upload to s3.
+	// Otherwise, images are stored in the local disk.
 	S3Enabled bool `env:"S3_ENABLED"`
 
 	// S3 stores config to connect to S3
 	S3 S3Config
+
+	// LocalStorage stores config of the local disk storage
+	LocalStorage LocalStorageConfig
+
+	// ImageURLTTL is how long a signed image URL can be used to download the image
+	ImageURLTTL time.Duration `env:"IMAGE_URL_TTL,default=5m"`
 }
 
 func InitializeConfig() Config {
@@ -9,16 +9,49 @@ import (
 )
 
 var (
-	ErrMalformedRequest     = fiber.NewError(http.StatusBadRequest, "request malformed")
-	ErrCredentialExists     = fiber.NewError(http.StatusConflict, "credential already used")
-	ErrWrongPassword        = fiber.NewError(http.StatusBadRequest, "wrong password entered")
-	ErrRequestForbidden     = fiber.NewError(http.StatusForbidden, "request forbidden")
-	ErrInsufficientBalance  = fiber.NewError(http.StatusBadRequest, "insufficient balance in currency")
-	ErrUserNotFound         = fiber.NewError(http.StatusNotFound, "user with the specified credential not found")
-	ErrPostNotFound         = fiber.NewError(http.StatusNotFound, "post not found")
-	ErrInvalidUploadedFile  = fiber.NewError(http.StatusBadRequest, "invalid uploaded file")
-	ErrInvalidFileSize      = fiber.NewError(http.StatusBadRequest, "invalid file size")
-	ErrInvalidFileExtension = fiber.NewError(http.StatusBadRequest, "invalid file extension")
+	ErrMalformedRequest      = fiber.NewError(http.StatusBadRequest, "request malformed")
+	ErrCredentialExists      = fiber.NewError(http.StatusConflict, "credential already used")
+	ErrWrongPassword         = fiber.NewError(http.StatusBadRequest, "wrong password entered")
+	ErrInvalidCredentials    = fiber.NewError(http.StatusUnauthorized, "invalid email or password")
+	ErrTooManyLoginAttempts  = fiber.NewError(http.StatusTooManyRequests, "too many failed login attempts")
+	ErrInvalidMFAToken       = fiber.NewError(http.StatusUnauthorized, "two-factor login token is invalid or expired")
+	ErrInvalidMFACode        = fiber.NewError(http.StatusUnauthorized, "invalid two-factor authentication code")
+	ErrMFANotEnrolled        = fiber.NewError(http.StatusNotFound, "two-factor authentication enrollment not found")
+	ErrMFAAlreadyEnabled     = fiber.NewError(http.StatusConflict, "two-factor authentication is already enabled")
+	ErrMFANotEnabled         = fiber.NewError(http.StatusForbidden, "two-factor authentication must be enabled for this request")
+	ErrStepUpRequired        = fiber.NewError(http.StatusForbidden, "a two-factor authentication code is required in the X-MFA-Code header for this amount")
+	ErrRequestForbidden      = fiber.NewError(http.StatusForbidden, "request forbidden")
+	ErrInvalidRefreshToken   = fiber.NewError(http.StatusUnauthorized, "refresh token is invalid or expired")
+	ErrInvalidResetToken     = fiber.NewError(http.StatusBadRequest, "password reset token is invalid or expired")
+	ErrInvalidEmailToken     = fiber.NewError(http.StatusBadRequest, "email verification token is invalid or expired")
+	ErrEmailAlreadyVerified  = fiber.NewError(http.StatusConflict, "email is already verified")
+	ErrEmailNotVerified      = fiber.NewError(http.StatusForbidden, "email must be verified before moving money")
+	ErrAccountFrozen         = fiber.NewError(http.StatusForbidden, "account is frozen, please contact support")
+	ErrAccountHasBalance     = fiber.NewError(http.StatusUnprocessableEntity, "account can't be closed while it still has a balance or a pending top-up")
+	ErrInsufficientBalance   = fiber.NewError(http.StatusBadRequest, "insufficient balance in currency")
+	ErrSelfTransfer          = fiber.NewError(http.StatusBadRequest, "can't transfer to your own account")
+	ErrExchangeRateNotFound  = fiber.NewError(http.StatusUnprocessableEntity, "exchange rate between the currencies is not available")
+	ErrExchangeAmountTooLow  = fiber.NewError(http.StatusBadRequest, "amount is too low to be exchanged")
+	ErrQuoteNotFound         = fiber.NewError(http.StatusNotFound, "exchange quote not found")
+	ErrQuoteExpired          = fiber.NewError(http.StatusUnprocessableEntity, "exchange quote has expired")
+	ErrQuoteUsed             = fiber.NewError(http.StatusConflict, "exchange quote has already been used")
+	ErrTransactionNotFound   = fiber.NewError(http.StatusNotFound, "transaction not found")
+	ErrAlreadyReversed       = fiber.NewError(http.StatusConflict, "transaction has already been reversed")
+	ErrNotReversible         = fiber.NewError(http.StatusUnprocessableEntity, "transaction can't be reversed")
+	ErrReversalOverdraws     = fiber.NewError(http.StatusUnprocessableEntity, "reversal would make the available balance negative")
+	ErrTopUpNotPending       = fiber.NewError(http.StatusConflict, "top-up is not pending review")
+	ErrHoldNotFound          = fiber.NewError(http.StatusNotFound, "hold not found")
+	ErrHoldReleased          = fiber.NewError(http.StatusConflict, "hold has already been released")
+	ErrUserNotFound          = fiber.NewError(http.StatusNotFound, "user with the specified credential not found")
+	ErrPostNotFound          = fiber.NewError(http.StatusNotFound, "post not found")
+	ErrInvalidUploadedFile   = fiber.NewError(http.StatusBadRequest, "invalid uploaded file")
+	ErrInvalidFileSize       = fiber.NewError(http.StatusBadRequest, "invalid file size")
+	ErrInvalidFileExtension  = fiber.NewError(http.StatusBadRequest, "invalid file extension")
+	ErrInvalidImageContent   = fiber.NewError(http.StatusBadRequest, "file is not a valid JPEG or PNG image")
+	ErrInvalidImageDimension = fiber.NewError(http.StatusBadRequest, "image dimension is too large")
+	ErrImageNotFound         = fiber.NewError(http.StatusNotFound, "image not found")
+	ErrProofImageNotFound    = fiber.NewError(http.StatusBadRequest, "transfer proof image is not uploaded by the user")
+	ErrProofImageUsed        = fiber.NewError(http.StatusConflict, "transfer proof image has already been used")
 )
 
 func DefaultErrorHandler() fiber.ErrorHandler {
@@ -27,6 +60,15 @@ func DefaultErrorHandler() fiber.ErrorHandler {
 		code := fiber.StatusInternalServerError
 		message := "internal server error"
 
+		// the limit errors also tell which limit is hit
+		var limitErr *LimitExceededError
+		if errors.As(err, &limitErr) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(model.ErrorResponse{
+				Message: limitErr.Error(),
+				Limit:   limitErr.Limit,
+			})
+		}
+
 		// Retrieve the custom status code & message if it's a *fiber.Error
 		var e *fiber.Error
 		if errors.As(err, &e) {
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the limits an outgoing transaction can hit
+const (
+	LimitPerTransaction = "PER_TRANSACTION"
+	LimitDaily          = "DAILY"
+	LimitMonthly        = "MONTHLY"
+	LimitHourlyCount    = "HOURLY_COUNT"
+)
+
+// LimitExceededError is responded with 422, and tells which limit the transaction hits
+type LimitExceededError struct {
+	Limit string
+	// Max is the amount of the limit in the major unit of Currency, or the number of transactions for LimitHourlyCount
+	Max      string
+	Currency string
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Limit == LimitHourlyCount {
+		return fmt.Sprintf("limit of %s transactions per hour exceeded", e.Max)
+	}
+
+	name := strings.ReplaceAll(strings.ToLower(e.Limit), "_", "-")
+	return fmt.Sprintf("%s limit of %s %s exceeded", name, e.Max, e.Currency)
+}
@@ -3,10 +3,37 @@ package config
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+func ConnectToDB(dbCfg DatabaseConfig) *sqlx.DB {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?%s",
+		dbCfg.Username, dbCfg.Password, dbCfg.Host,
+		dbCfg.Port, dbCfg.Name, dbCfg.Params,
+	)
+
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	db.SetMaxOpenConns(dbCfg.MaxOpenConnection)
+	db.SetMaxIdleConns(dbCfg.MaxIdleConnection)
+	db.SetConnMaxLifetime(time.Duration(dbCfg.MaxConnLifetime) * time.Minute)
+	db.SetConnMaxIdleTime(time.Duration(dbCfg.MaxConnIdleTime) * time.Minute)
+
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
 type TransactionProvider struct {
 	db *sqlx.DB
 }
@@ -1,23 +1,52 @@
 package image
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
 	"strings"
+	"time"
 
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/user"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
-	"github.com/ahmadnaufal/openidea-paimonbank/pkg/s3"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/storage"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
+var allowedExtensions = map[string]bool{
+	"jpg":  true,
+	"jpeg": true,
+	"png":  true,
+}
+
 type imageHandler struct {
-	s3Provider *s3.S3Provider
+	objectStore  storage.ObjectStore
+	imageRepo    *ImageRepo
+	auditLogger  user.AuditLogger
+	signedURLTTL time.Duration
+}
+
+type ImageHandlerConfig struct {
+	ObjectStore  storage.ObjectStore
+	ImageRepo    *ImageRepo
+	AuditLogger  user.AuditLogger
+	SignedURLTTL time.Duration
 }
 
-func NewImageHandler(s3prov *s3.S3Provider) imageHandler {
+func NewImageHandler(cfg ImageHandlerConfig) imageHandler {
 	return imageHandler{
-		s3Provider: s3prov,
+		objectStore:  cfg.ObjectStore,
+		imageRepo:    cfg.ImageRepo,
+		auditLogger:  cfg.AuditLogger,
+		signedURLTTL: cfg.SignedURLTTL,
 	}
 }
 
@@ -26,11 +55,15 @@ func (h *imageHandler) RegisterRoute(r *fiber.App, jwtProvider jwt.JWTProvider)
 	authMiddleware := jwtProvider.Middleware()
 
 	imageGroup.Post("/", authMiddleware, h.UploadImage)
+	imageGroup.Get("/:imageId/url", authMiddleware, h.GetImageURL)
+
+	staffMiddleware := jwt.RequireRoles(jwt.RoleSupport, jwt.RoleAdmin)
+	r.Get("/v1/admin/images/:imageId/url", authMiddleware, staffMiddleware, h.GetImageURLForStaff)
 }
 
 func (h *imageHandler) UploadImage(c *fiber.Ctx) error {
 	// check for credentials
-	_, err := jwt.GetLoggedInUser(c)
+	claims, err := jwt.GetLoggedInUser(c)
 	if err != nil {
 		return config.ErrRequestForbidden
 	}
@@ -46,21 +79,140 @@ func (h *imageHandler) UploadImage(c *fiber.Ctx) error {
 		return config.ErrInvalidFileSize
 	}
 
-	// check extension
+	// check extension. The content is checked separately, so the extension only has to look right
 	fp := strings.Split(fileReader.Filename, ".")
-	if len(fp) < 2 || (fp[len(fp)-1] != "jpg" && fp[len(fp)-1] != "jpeg") {
+	if len(fp) < 2 || !allowedExtensions[strings.ToLower(fp[len(fp)-1])] {
 		return config.ErrInvalidFileExtension
 	}
 
-	imgUrl, err := h.s3Provider.UploadImage(c.Context(), fileReader)
+	img, err := readImage(fileReader)
+	if err != nil {
+		return err
+	}
+
+	// the stored image is the sanitized one, so it's the one hashed and measured
+	ctx := c.Context()
+	key := fmt.Sprintf("%s.%s", uuid.NewString(), img.Extension)
+	object, err := h.objectStore.Put(ctx, key, bytes.NewReader(img.Data), img.ContentType)
 	if err != nil {
 		return err
 	}
+	contentHash := sha256.Sum256(img.Data)
+
+	// every upload is recorded, so the image can be traced back to its owner when it's used elsewhere
+	imageID := uuid.NewString()
+	err = h.imageRepo.CreateImage(ctx, Image{
+		ID:          imageID,
+		UserID:      claims.UserID,
+		ObjectKey:   object.Key,
+		URL:         object.URL,
+		Size:        int64(len(img.Data)),
+		ContentHash: hex.EncodeToString(contentHash[:]),
+	})
+	if err != nil {
+		return errors.Wrap(err, "CreateImage error")
+	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
 		Message: "File uploaded successfully",
 		Data: ImageUploadResponse{
-			ImageURL: imgUrl,
+			ImageID:  imageID,
+			ImageURL: object.URL,
+		},
+	})
+}
+
+// GetImageURL gives a short-lived URL to download the user's own image
+func (h *imageHandler) GetImageURL(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	return h.handleImageURL(c, claims.UserID, false)
+}
+
+// GetImageURLForStaff gives a short-lived URL to download any image, e.g. to review a top-up's transfer proof.
+// It's only available for support staff and admins, and every access is logged.
+func (h *imageHandler) GetImageURLForStaff(c *fiber.Ctx) error {
+	return h.handleImageURL(c, "", true)
+}
+
+// handleImageURL signs the URL of the image. If ownerID is set, the image must belong to them.
+// If audited, the access is logged against the owner of the image.
+func (h *imageHandler) handleImageURL(c *fiber.Ctx, ownerID string, audited bool) error {
+	var payload GetImageURLRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Context()
+	img, err := h.imageRepo.GetImageByID(ctx, payload.ImageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrImageNotFound
+		}
+
+		return errors.Wrap(err, "GetImageByID error")
+	}
+
+	// other users' images are reported as missing, so their existence isn't leaked
+	if ownerID != "" && img.UserID != ownerID {
+		return config.ErrImageNotFound
+	}
+
+	if audited {
+		if err := h.logStaffAccess(c, img); err != nil {
+			return err
+		}
+	}
+
+	expiresAt := time.Now().Add(h.signedURLTTL)
+	signedURL, err := h.objectStore.SignedURL(ctx, img.ObjectKey, h.signedURLTTL)
+	if err != nil {
+		return errors.Wrap(err, "SignedURL error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data: ImageURLResponse{
+			ImageID:   img.ID,
+			ImageURL:  signedURL,
+			ExpiresAt: uint64(expiresAt.UnixMilli()),
 		},
 	})
 }
+
+// logStaffAccess records the staff member who read the image, like the admin API does
+func (h *imageHandler) logStaffAccess(c *fiber.Ctx, img Image) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	err = h.auditLogger.Log(c.Context(), user.AuditLog{
+		Event:     user.AuditEventImageURLSigned,
+		Subject:   img.UserID,
+		IPAddress: c.IP(),
+		Detail:    fmt.Sprintf("by %s: signed the URL of image %s", claims.UserID, img.ID),
+	})
+	if err != nil {
+		return errors.Wrap(err, "audit log error")
+	}
+
+	return nil
+}
+
+func readImage(fileHeader *multipart.FileHeader) (sanitizedImage, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return sanitizedImage{}, errors.Wrap(err, "fileHeader.Open() error")
+	}
+	defer file.Close()
+
+	return sanitizeImage(file)
+}
@@ -0,0 +1,21 @@
+package image
+
+import (
+	"database/sql"
+	"time"
+)
+
+type GetImageURLRequest struct {
+	ImageID string `params:"imageId" validate:"required,uuid"`
+}
+
+type Image struct {
+	ID          string         `db:"id"`
+	UserID      string         `db:"user_id"`
+	ObjectKey   string         `db:"object_key"`
+	URL         string         `db:"url"`
+	Size        int64          `db:"size"`
+	ContentHash string         `db:"content_hash"`
+	UsedBy      sql.NullString `db:"used_by"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
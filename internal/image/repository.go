@@ -0,0 +1,115 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ImageRepo struct {
+	db *sqlx.DB
+}
+
+func NewImageRepo(db *sqlx.DB) ImageRepo {
+	return ImageRepo{db: db}
+}
+
+func (r *ImageRepo) CreateImage(ctx context.Context, image Image) error {
+	query := `
+		INSERT INTO images
+			(id, user_id, object_key, url, size, content_hash)
+		VALUES
+			(:id, :user_id, :object_key, :url, :size, :content_hash)
+	`
+
+	updatedQuery, args, err := sqlx.Named(query, image)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, updatedQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *ImageRepo) GetImageByID(ctx context.Context, id string) (Image, error) {
+	var result Image
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			object_key,
+			url,
+			size,
+			content_hash,
+			used_by,
+			created_at
+		FROM
+			images
+		WHERE
+			id = $1
+		LIMIT 1
+	`
+
+	err := r.db.GetContext(ctx, &result, query, id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetImageByURLForUpdate returns the image and locks it until tx ends, so it can't be claimed twice
+func (r *ImageRepo) GetImageByURLForUpdate(ctx context.Context, tx *sql.Tx, url string) (Image, error) {
+	var result Image
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			object_key,
+			url,
+			size,
+			content_hash,
+			used_by,
+			created_at
+		FROM
+			images
+		WHERE
+			url = $1
+		FOR UPDATE
+	`
+
+	txx := &sqlx.Tx{Tx: tx, Mapper: r.db.Mapper}
+	err := txx.GetContext(ctx, &result, query, url)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// MarkImageUsed records the top-up which used the image as its transfer proof
+func (r *ImageRepo) MarkImageUsed(ctx context.Context, tx *sql.Tx, id, balanceHistoryID string) error {
+	query := `
+		UPDATE
+			images
+		SET
+			used_by = $2,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, balanceHistoryID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,5 +1,12 @@
 package image
 
 type ImageUploadResponse struct {
+	ImageID  string `json:"imageId"`
 	ImageURL string `json:"imageUrl"`
 }
+
+type ImageURLResponse struct {
+	ImageID   string `json:"imageId"`
+	ImageURL  string `json:"imageUrl"`
+	ExpiresAt uint64 `json:"expiresAt"`
+}
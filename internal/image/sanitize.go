@@ -0,0 +1,79 @@
+package image
+
+import (
+	"bytes"
+	stdimage "image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxImageWidth  = 4096
+	maxImageHeight = 4096
+
+	jpegQuality = 95
+)
+
+var (
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+	pngMagic  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+)
+
+type sanitizedImage struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+// sanitizeImage makes sure the file is a well-formed JPEG or PNG, whatever its name says.
+// The image is re-encoded, which drops the metadata carried with it, like EXIF and GPS location.
+func sanitizeImage(r io.Reader) (sanitizedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return sanitizedImage{}, errors.Wrap(err, "io.ReadAll error")
+	}
+
+	var result sanitizedImage
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		result.ContentType, result.Extension = "image/jpeg", "jpg"
+	case bytes.HasPrefix(data, pngMagic):
+		result.ContentType, result.Extension = "image/png", "png"
+	default:
+		return sanitizedImage{}, config.ErrInvalidImageContent
+	}
+
+	// check the dimension before decoding the whole image, so a small file can't take up a lot of memory
+	imgConfig, format, err := stdimage.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return sanitizedImage{}, errors.Wrap(config.ErrInvalidImageContent, err.Error())
+	}
+	if imgConfig.Width > maxImageWidth || imgConfig.Height > maxImageHeight {
+		return sanitizedImage{}, config.ErrInvalidImageDimension
+	}
+
+	img, _, err := stdimage.Decode(bytes.NewReader(data))
+	if err != nil {
+		return sanitizedImage{}, errors.Wrap(config.ErrInvalidImageContent, err.Error())
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		err = png.Encode(&buf, img)
+	default:
+		return sanitizedImage{}, config.ErrInvalidImageContent
+	}
+	if err != nil {
+		return sanitizedImage{}, errors.Wrap(err, "image encode error")
+	}
+	result.Data = buf.Bytes()
+
+	return result, nil
+}
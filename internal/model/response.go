@@ -1,9 +1,11 @@
 package model
 
 type ResponseMeta struct {
-	Limit  uint `json:"limit"`
-	Offset uint `json:"offset"`
-	Total  uint `json:"total"`
+	Limit      uint   `json:"limit"`
+	Offset     uint   `json:"offset"`
+	Total      *uint  `json:"total,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
 }
 
 type DataResponse struct {
@@ -14,4 +16,6 @@ type DataResponse struct {
 
 type ErrorResponse struct {
 	Message string `json:"message"`
+	// Limit is the limit a transaction hits, if that's why it fails
+	Limit string `json:"limit,omitempty"`
 }
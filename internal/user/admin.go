@@ -0,0 +1,267 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// SearchUsers finds users by their ID, email or name. It's only available for support staff and admins.
+func (h *userHandler) SearchUsers(c *fiber.Ctx) error {
+	var payload SearchUsersRequest
+	if err := c.QueryParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	users, err := h.userRepo.SearchUsers(c.Context(), payload)
+	if err != nil {
+		return errors.Wrap(err, "SearchUsers error")
+	}
+
+	responses := []AdminUserResponse{}
+	for _, user := range users {
+		responses = append(responses, newAdminUserResponse(user))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    responses,
+		Meta: &model.ResponseMeta{
+			Limit:  payload.Limit,
+			Offset: payload.Offset,
+		},
+	})
+}
+
+// GetUser returns any user, including the closed accounts. It's only available for support staff and admins.
+func (h *userHandler) GetUser(c *fiber.Ctx) error {
+	var payload AdminUserRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.getUserForAdmin(c.Context(), payload.UserID)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newAdminUserResponse(user),
+	})
+}
+
+// FreezeUser stops the user from moving money, e.g. when the account is suspected to be compromised.
+// It's only available for support staff and admins.
+func (h *userHandler) FreezeUser(c *fiber.Ctx) error {
+	var payload FreezeUserRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Context()
+	user, err := h.getUserForAdmin(ctx, payload.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = h.userRepo.FreezeUser(ctx, user.ID, payload.Reason)
+	if err != nil {
+		return errors.Wrap(err, "FreezeUser error")
+	}
+
+	err = h.logAdminAction(c, AuditEventUserFrozen, user.ID, payload.Reason)
+	if err != nil {
+		return err
+	}
+
+	return h.respondAdminUser(c, user.ID, "User frozen successfully")
+}
+
+// UnfreezeUser lets the user move money again. It's only available for admins.
+func (h *userHandler) UnfreezeUser(c *fiber.Ctx) error {
+	var payload AdminUserRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Context()
+	user, err := h.getUserForAdmin(ctx, payload.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = h.userRepo.UnfreezeUser(ctx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "UnfreezeUser error")
+	}
+
+	err = h.logAdminAction(c, AuditEventUserUnfrozen, user.ID, "")
+	if err != nil {
+		return err
+	}
+
+	return h.respondAdminUser(c, user.ID, "User unfrozen successfully")
+}
+
+// UpdateRole changes the role of the user. Every session of the user is logged out,
+// so the new role is in effect right away. It's only available for admins.
+func (h *userHandler) UpdateRole(c *fiber.Ctx) error {
+	var payload UpdateRoleRequest
+	if err := c.ParamsParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	// admins can't demote themselves, so there's always an admin left to undo a mistake
+	if claims.UserID == payload.UserID {
+		return config.ErrRequestForbidden
+	}
+
+	ctx := c.Context()
+	err = h.updateRole(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	err = h.logAdminAction(c, AuditEventUserRoleChanged, payload.UserID, payload.Role)
+	if err != nil {
+		return err
+	}
+
+	return h.respondAdminUser(c, payload.UserID, "User role updated successfully")
+}
+
+func (h *userHandler) updateRole(ctx context.Context, payload UpdateRoleRequest) error {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	user, err := h.userRepo.GetUserByIDForUpdate(ctx, tx, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrUserNotFound
+		}
+
+		return errors.Wrap(err, "GetUserByIDForUpdate error")
+	}
+
+	err = h.userRepo.UpdateRole(ctx, tx, user.ID, payload.Role)
+	if err != nil {
+		return errors.Wrap(err, "UpdateRole error")
+	}
+
+	// the role is carried in the access tokens, so the ones already issued must not be used anymore
+	err = h.userRepo.RevokeUserSessions(ctx, tx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "RevokeUserSessions error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Commit error")
+	}
+
+	return nil
+}
+
+func (h *userHandler) getUserForAdmin(ctx context.Context, userID string) (User, error) {
+	user, err := h.userRepo.GetUserByIDIncludingClosed(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, config.ErrUserNotFound
+		}
+
+		return User{}, errors.Wrap(err, "GetUserByIDIncludingClosed error")
+	}
+
+	return user, nil
+}
+
+func (h *userHandler) respondAdminUser(c *fiber.Ctx, userID, message string) error {
+	user, err := h.getUserForAdmin(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: message,
+		Data:    newAdminUserResponse(user),
+	})
+}
+
+// logAdminAction records who did what to the user
+func (h *userHandler) logAdminAction(c *fiber.Ctx, event, userID, detail string) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	err = h.auditLogger.Log(c.Context(), AuditLog{
+		Event:     event,
+		Subject:   userID,
+		IPAddress: c.IP(),
+		Detail:    fmt.Sprintf("by %s: %s", claims.UserID, detail),
+	})
+	if err != nil {
+		return errors.Wrap(err, "audit log error")
+	}
+
+	return nil
+}
+
+func newAdminUserResponse(user User) AdminUserResponse {
+	return AdminUserResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		Tier:          user.Tier,
+		EmailVerified: user.EmailVerified(),
+		Frozen:        user.Frozen(),
+		FrozenReason:  user.FrozenReason.String,
+		Closed:        user.DeletedAt.Valid,
+		CreatedAt:     uint64(user.CreatedAt.UnixMilli()),
+	}
+}
@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	AuditEventLoginLockout    = "LOGIN_LOCKOUT"
+	AuditEventStepUpLockout   = "STEP_UP_LOCKOUT"
+	AuditEventUserFrozen      = "USER_FROZEN"
+	AuditEventUserUnfrozen    = "USER_UNFROZEN"
+	AuditEventUserRoleChanged = "USER_ROLE_CHANGED"
+
+	AuditEventTransactionReversed = "TRANSACTION_REVERSED"
+	AuditEventTopUpApproved       = "TOPUP_APPROVED"
+	AuditEventTopUpRejected       = "TOPUP_REJECTED"
+	AuditEventImageURLSigned      = "IMAGE_URL_SIGNED"
+)
+
+type AuditLog struct {
+	ID        string `db:"id"`
+	Event     string `db:"event"`
+	Subject   string `db:"subject"`
+	IPAddress string `db:"ip_address"`
+	Detail    string `db:"detail"`
+}
+
+// AuditLogger records security related events
+type AuditLogger interface {
+	Log(ctx context.Context, log AuditLog) error
+	// LogTx records the event in tx, so it's only kept if what it describes is committed with it
+	LogTx(ctx context.Context, tx *sql.Tx, log AuditLog) error
+}
+
+type PostgresAuditLogger struct {
+	db *sqlx.DB
+}
+
+func NewPostgresAuditLogger(db *sqlx.DB) PostgresAuditLogger {
+	return PostgresAuditLogger{db: db}
+}
+
+func (l *PostgresAuditLogger) Log(ctx context.Context, log AuditLog) error {
+	return l.insert(ctx, l.db, log)
+}
+
+func (l *PostgresAuditLogger) LogTx(ctx context.Context, tx *sql.Tx, log AuditLog) error {
+	return l.insert(ctx, tx, log)
+}
+
+func (l *PostgresAuditLogger) insert(ctx context.Context, db sqlx.ExecerContext, log AuditLog) error {
+	if log.ID == "" {
+		log.ID = uuid.NewString()
+	}
+
+	query := `
+		INSERT INTO auth_audit_logs
+			(id, event, subject, ip_address, detail)
+		VALUES
+			(:id, :event, :subject, :ip_address, :detail)
+	`
+
+	updatedQuery, args, err := sqlx.Named(query, log)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, updatedQuery), args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -3,11 +3,13 @@ package user
 import (
 	"context"
 	"database/sql"
+	"log"
 	"time"
 
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
 	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/notifier"
 	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -16,30 +18,94 @@ import (
 )
 
 type userHandler struct {
-	userRepo    *UserRepo
-	jwtProvider *jwt.JWTProvider
-	saltCost    int
+	userRepo                  *UserRepo
+	jwtProvider               *jwt.JWTProvider
+	trxProvider               *config.TransactionProvider
+	loginThrottler            *LoginThrottler
+	totpVerifier              *TOTPVerifier
+	notifier                  notifier.Notifier
+	auditLogger               AuditLogger
+	balanceChecker            BalanceChecker
+	saltCost                  int
+	accessTokenTTL            time.Duration
+	refreshTokenTTL           time.Duration
+	passwordResetTokenTTL     time.Duration
+	emailVerificationTokenTTL time.Duration
+	mfaChallengeTTL           time.Duration
+
+	// dummyPasswordHash is compared against when the user doesn't exist,
+	// so a login takes as long whether the email is registered or not
+	dummyPasswordHash []byte
 }
 
 type UserHandlerConfig struct {
-	UserRepo    *UserRepo
-	JwtProvider *jwt.JWTProvider
-	SaltCost    int
+	UserRepo                  *UserRepo
+	JwtProvider               *jwt.JWTProvider
+	TrxProvider               *config.TransactionProvider
+	LoginThrottler            *LoginThrottler
+	TOTPVerifier              *TOTPVerifier
+	Notifier                  notifier.Notifier
+	AuditLogger               AuditLogger
+	BalanceChecker            BalanceChecker
+	SaltCost                  int
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	PasswordResetTokenTTL     time.Duration
+	EmailVerificationTokenTTL time.Duration
+	MFAChallengeTTL           time.Duration
 }
 
 func NewUserHandler(cfg UserHandlerConfig) userHandler {
+	dummyPasswordHash, _ := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), cfg.SaltCost)
+
 	return userHandler{
-		userRepo:    cfg.UserRepo,
-		jwtProvider: cfg.JwtProvider,
-		saltCost:    cfg.SaltCost,
+		userRepo:                  cfg.UserRepo,
+		jwtProvider:               cfg.JwtProvider,
+		trxProvider:               cfg.TrxProvider,
+		loginThrottler:            cfg.LoginThrottler,
+		totpVerifier:              cfg.TOTPVerifier,
+		notifier:                  cfg.Notifier,
+		auditLogger:               cfg.AuditLogger,
+		balanceChecker:            cfg.BalanceChecker,
+		saltCost:                  cfg.SaltCost,
+		accessTokenTTL:            cfg.AccessTokenTTL,
+		refreshTokenTTL:           cfg.RefreshTokenTTL,
+		passwordResetTokenTTL:     cfg.PasswordResetTokenTTL,
+		emailVerificationTokenTTL: cfg.EmailVerificationTokenTTL,
+		mfaChallengeTTL:           cfg.MFAChallengeTTL,
+		dummyPasswordHash:         dummyPasswordHash,
 	}
 }
 
-func (h *userHandler) RegisterRoute(r *fiber.App, _ jwt.JWTProvider) {
+func (h *userHandler) RegisterRoute(r *fiber.App, jwtProvider jwt.JWTProvider) {
 	userGroup := r.Group("/v1/user")
+	authMiddleware := jwtProvider.Middleware()
 
 	userGroup.Post("/register", h.RegisterUser)
 	userGroup.Post("/login", h.Authenticate)
+	userGroup.Post("/login/mfa", h.VerifyMFA)
+	userGroup.Post("/refresh", h.RefreshToken)
+	userGroup.Post("/logout", authMiddleware, h.Logout)
+	userGroup.Post("/password", authMiddleware, h.ChangePassword)
+	userGroup.Post("/password/reset-request", h.RequestPasswordReset)
+	userGroup.Post("/password/reset", h.ResetPassword)
+	userGroup.Post("/mfa/totp", authMiddleware, h.EnrollTOTP)
+	userGroup.Post("/mfa/totp/confirm", authMiddleware, h.ConfirmTOTP)
+	userGroup.Get("/me", authMiddleware, h.GetProfile)
+	userGroup.Patch("/me", authMiddleware, h.UpdateProfile)
+	userGroup.Delete("/me", authMiddleware, h.CloseAccount)
+	userGroup.Post("/email/verify", h.VerifyEmail)
+	userGroup.Post("/email/verify/resend", authMiddleware, h.ResendEmailVerification)
+
+	staffMiddleware := jwt.RequireRoles(jwt.RoleSupport, jwt.RoleAdmin)
+	adminMiddleware := jwt.RequireRoles(jwt.RoleAdmin)
+
+	adminGroup := r.Group("/v1/admin/users")
+	adminGroup.Get("/", authMiddleware, staffMiddleware, h.SearchUsers)
+	adminGroup.Get("/:userId", authMiddleware, staffMiddleware, h.GetUser)
+	adminGroup.Post("/:userId/freeze", authMiddleware, staffMiddleware, h.FreezeUser)
+	adminGroup.Post("/:userId/unfreeze", authMiddleware, adminMiddleware, h.UnfreezeUser)
+	adminGroup.Put("/:userId/role", authMiddleware, adminMiddleware, h.UpdateRole)
 }
 
 func (h *userHandler) RegisterUser(c *fiber.Ctx) error {
@@ -53,35 +119,37 @@ func (h *userHandler) RegisterUser(c *fiber.Ctx) error {
 	}
 
 	// find existing user by credentials
-	user, accessToken, err := h.createUser(c.Context(), payload)
+	user, tokens, err := h.createUser(c.Context(), payload)
 	if err != nil {
 		return errors.Wrap(err, "create user error")
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(model.DataResponse{
-		Message: "User registered successfully",
+		Message: "User registered successfully. A verification token has been sent to the email",
 		Data: UserResponse{
-			Email:       user.Email,
-			Name:        user.Name,
-			AccessToken: accessToken,
+			Email:        user.Email,
+			Name:         user.Name,
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
 		},
 	})
 }
 
-func (h *userHandler) createUser(ctx context.Context, payload RegisterUserRequest) (User, string, error) {
-	_, err := h.userRepo.GetUserByEmail(ctx, payload.Email)
-	if err != nil && err != sql.ErrNoRows {
-		return User{}, "", errors.Wrap(err, "GetUserByCredential error")
+func (h *userHandler) createUser(ctx context.Context, payload RegisterUserRequest) (User, authTokens, error) {
+	// closed accounts keep their email, so it can't be registered again either
+	exists, err := h.userRepo.EmailExists(ctx, payload.Email)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "EmailExists error")
 	}
-	if err == nil {
+	if exists {
 		// user already exists
-		return User{}, "", config.ErrCredentialExists
+		return User{}, authTokens{}, config.ErrCredentialExists
 	}
 
 	// hash the password first using bcrypt
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(payload.Password), h.saltCost)
 	if err != nil {
-		return User{}, "", err
+		return User{}, authTokens{}, err
 	}
 
 	user := User{
@@ -89,19 +157,43 @@ func (h *userHandler) createUser(ctx context.Context, payload RegisterUserReques
 		Name:     payload.Name,
 		Email:    payload.Email,
 		Password: string(hashedPassword),
+		Role:     jwt.RoleCustomer,
 	}
-	err = h.userRepo.CreateUser(ctx, user)
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return user, authTokens{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	err = h.userRepo.CreateUser(ctx, tx, user)
+	if err != nil {
+		return user, authTokens{}, err
+	}
+
+	// the user can log in right away, but can't move money until the email is verified
+	token, err := h.createEmailVerificationToken(ctx, tx, user.ID, user.Email)
 	if err != nil {
-		return user, "", err
+		return user, authTokens{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return user, authTokens{}, errors.Wrap(err, "Commit error")
+	}
+
+	// the user is already registered, and can ask for the token again if it doesn't arrive
+	err = h.notifyEmailVerification(ctx, user.Email, token)
+	if err != nil {
+		log.Printf("failed to send the email verification of user %s: %v", user.ID, err)
 	}
 
 	// generate JWT
-	accessToken, err := h.generateAccessTokenFromUser(user)
+	tokens, err := h.startSession(ctx, user)
 	if err != nil {
-		return user, "", err
+		return user, authTokens{}, err
 	}
 
-	return user, accessToken, nil
+	return user, tokens, nil
 }
 
 func (h *userHandler) Authenticate(c *fiber.Ctx) error {
@@ -114,50 +206,108 @@ func (h *userHandler) Authenticate(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	user, accessToken, err := h.authenticateUser(c.Context(), payload)
+	ctx := c.Context()
+	user, err := h.authenticateUser(ctx, payload, c.IP())
 	if err != nil {
-		return errors.Wrap(err, "create user error")
+		var throttledErr *ThrottledLoginError
+		if errors.As(err, &throttledErr) {
+			c.Set(fiber.HeaderRetryAfter, throttledErr.RetryAfterSeconds())
+		}
+
+		return errors.Wrap(err, "authenticate user error")
+	}
+
+	// with MFA enabled, the password only gets the user to the second step
+	mfaEnabled, err := h.totpVerifier.IsEnabled(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if mfaEnabled {
+		mfaToken, err := h.startLoginChallenge(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+			Message: "Two-factor authentication is required",
+			Data: MFAChallengeResponse{
+				MFARequired: true,
+				MFAToken:    mfaToken,
+			},
+		})
+	}
+
+	tokens, err := h.startSession(ctx, user)
+	if err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
 		Message: "User logged successfully",
 		Data: UserResponse{
-			Email:       user.Email,
-			Name:        user.Name,
-			AccessToken: accessToken,
+			Email:        user.Email,
+			Name:         user.Name,
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
 		},
 	})
 }
 
-func (h *userHandler) authenticateUser(ctx context.Context, payload AuthenticateRequest) (User, string, error) {
-	user, err := h.userRepo.GetUserByEmail(ctx, payload.Email)
+// authenticateUser verifies the password of the user
+func (h *userHandler) authenticateUser(ctx context.Context, payload AuthenticateRequest, ip string) (User, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return user, "", config.ErrUserNotFound
+		return User{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// the attempts stay locked through the password check, until its result is recorded
+	if err := h.loginThrottler.Check(ctx, tx, payload.Email, ip); err != nil {
+		return User{}, err
+	}
+
+	// an unknown email and a wrong password fail the same way, so registered emails can't be enumerated
+	user, err := h.userRepo.GetUserByEmail(ctx, payload.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return user, errors.Wrap(err, "GetUserByCredential error")
+	}
+
+	passwordHash := []byte(user.Password)
+	if err == sql.ErrNoRows {
+		passwordHash = h.dummyPasswordHash
+	}
+
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(payload.Password)); err != nil || user.ID == "" {
+		if err := h.loginThrottler.RecordFailure(ctx, tx, payload.Email, ip); err != nil {
+			return User{}, errors.Wrap(err, "RecordFailure error")
+		}
+
+		if err := tx.Commit(); err != nil {
+			return User{}, errors.Wrap(err, "Commit error")
 		}
 
-		return user, "", errors.Wrap(err, "GetUserByCredential error")
+		return User{}, config.ErrInvalidCredentials
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
-		return user, "", config.ErrWrongPassword
+	if err := h.loginThrottler.RecordSuccess(ctx, tx, payload.Email); err != nil {
+		return user, errors.Wrap(err, "RecordSuccess error")
 	}
 
-	// generate JWT
-	accessToken, err := h.generateAccessTokenFromUser(user)
-	if err != nil {
-		return user, "", errors.Wrap(err, "generateAccessToken error")
+	if err := tx.Commit(); err != nil {
+		return user, errors.Wrap(err, "Commit error")
 	}
 
-	return user, accessToken, nil
+	return user, nil
 }
 
-func (h *userHandler) generateAccessTokenFromUser(user User) (string, error) {
+func (h *userHandler) generateAccessTokenFromUser(user User, sessionID string) (string, error) {
 	claims := jwt.BuildJWTClaims(jwt.JWTUser{
-		UserID: user.ID,
-		Name:   user.Name,
-		Email:  user.Email,
-	}, 8*time.Hour)
+		UserID:    user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
+	}, h.accessTokenTTL)
 
 	accessToken, err := h.jwtProvider.GenerateToken(claims)
 	if err != nil {
@@ -0,0 +1,157 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresLoginAttemptStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sqlx.DB) PostgresLoginAttemptStore {
+	return PostgresLoginAttemptStore{db: db}
+}
+
+func (s *PostgresLoginAttemptStore) txx(tx *sql.Tx) *sqlx.Tx {
+	return &sqlx.Tx{Tx: tx, Mapper: s.db.Mapper}
+}
+
+func (s *PostgresLoginAttemptStore) GetForUpdate(ctx context.Context, tx *sql.Tx, key string) (LoginAttempt, error) {
+	var result LoginAttempt
+
+	// the row is created if it's missing, so even the first attempts of a key are locked against each other
+	query := `
+		INSERT INTO login_attempts
+			(attempt_key, failures, last_failed_at)
+		VALUES
+			($1, 0, 'epoch')
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			attempt_key = EXCLUDED.attempt_key
+		RETURNING
+			attempt_key,
+			failures,
+			last_failed_at,
+			COALESCE(locked_until, 'epoch') AS locked_until,
+			NOW()::TIMESTAMP(0) AS now
+	`
+
+	err := sqlx.GetContext(ctx, s.txx(tx), &result, query, key)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *PostgresLoginAttemptStore) RecordFailure(ctx context.Context, tx *sql.Tx, key string, window time.Duration) (LoginAttempt, error) {
+	var result LoginAttempt
+
+	query := `
+		INSERT INTO login_attempts
+			(attempt_key, failures, last_failed_at)
+		VALUES
+			($1, 1, NOW())
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failed_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failed_at = NOW()
+		RETURNING
+			attempt_key,
+			failures,
+			last_failed_at,
+			COALESCE(locked_until, 'epoch') AS locked_until,
+			NOW()::TIMESTAMP(0) AS now
+	`
+
+	err := sqlx.GetContext(ctx, s.txx(tx), &result, query, key, window.Seconds())
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *PostgresLoginAttemptStore) Lock(ctx context.Context, tx *sql.Tx, key string, duration time.Duration) error {
+	query := `
+		UPDATE
+			login_attempts
+		SET
+			failures = 0,
+			locked_until = NOW() + make_interval(secs => $2)
+		WHERE
+			attempt_key = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, key, duration.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresLoginAttemptStore) Reset(ctx context.Context, tx *sql.Tx, key string) error {
+	query := `
+		DELETE FROM
+			login_attempts
+		WHERE
+			attempt_key = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes the attempts which no longer hold anything back: their lockout is over, and their
+// last failure is older than window. This includes the rows GetForUpdate created for keys which never failed.
+func (s *PostgresLoginAttemptStore) DeleteExpired(ctx context.Context, window time.Duration) (int64, error) {
+	query := `
+		DELETE FROM
+			login_attempts
+		WHERE
+			(locked_until IS NULL OR locked_until < NOW())
+			AND last_failed_at < NOW() - make_interval(secs => $1)
+	`
+
+	res, err := s.db.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// StartCleanup periodically deletes the expired attempts until ctx is cancelled
+func (s *PostgresLoginAttemptStore) StartCleanup(ctx context.Context, interval, window time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := s.DeleteExpired(ctx, window)
+				if err != nil {
+					log.Println("failed to delete expired login attempts: ", err)
+					continue
+				}
+				if deleted > 0 {
+					log.Printf("deleted %d expired login attempts", deleted)
+				}
+			}
+		}
+	}()
+}
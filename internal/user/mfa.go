@@ -0,0 +1,273 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/totp"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	totpIssuer = "PaimonBank"
+
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10
+)
+
+// EnrollTOTP starts the TOTP enrollment. It isn't enabled until it's confirmed with a code.
+func (h *userHandler) EnrollTOTP(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+
+	started, err := h.userRepo.UpsertPendingTOTP(c.Context(), claims.UserID, secret)
+	if err != nil {
+		return errors.Wrap(err, "UpsertPendingTOTP error")
+	}
+	if !started {
+		return config.ErrMFAAlreadyEnabled
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data: TOTPEnrollmentResponse{
+			Secret: secret,
+			URI:    totp.KeyURI(totpIssuer, claims.Email, secret),
+		},
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves the authenticator works. The recovery codes are only shown here.
+func (h *userHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var payload ConfirmTOTPRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	recoveryCodes, err := h.confirmTOTP(c.Context(), payload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data: RecoveryCodesResponse{
+			RecoveryCodes: recoveryCodes,
+		},
+	})
+}
+
+func (h *userHandler) confirmTOTP(ctx context.Context, payload ConfirmTOTPRequest) ([]string, error) {
+	userTOTP, err := h.userRepo.GetTOTP(ctx, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, config.ErrMFANotEnrolled
+		}
+
+		return nil, errors.Wrap(err, "GetTOTP error")
+	}
+	if userTOTP.EnabledAt.Valid {
+		return nil, config.ErrMFAAlreadyEnabled
+	}
+
+	if err := h.totpVerifier.useCode(ctx, userTOTP, payload.Code); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, codeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	err = h.userRepo.EnableTOTP(ctx, tx, payload.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "EnableTOTP error")
+	}
+
+	err = h.userRepo.ReplaceRecoveryCodes(ctx, tx, payload.UserID, codeHashes)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReplaceRecoveryCodes error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Commit error")
+	}
+
+	return recoveryCodes, nil
+}
+
+// VerifyMFA completes a login which needs the second factor, and starts its session
+func (h *userHandler) VerifyMFA(c *fiber.Ctx) error {
+	var payload VerifyMFARequest
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	user, tokens, err := h.verifyMFA(c.Context(), payload, c.IP())
+	if err != nil {
+		var throttledErr *ThrottledLoginError
+		if errors.As(err, &throttledErr) {
+			c.Set(fiber.HeaderRetryAfter, throttledErr.RetryAfterSeconds())
+		}
+
+		return errors.Wrap(err, "verify mfa error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "User logged successfully",
+		Data: UserResponse{
+			Email:        user.Email,
+			Name:         user.Name,
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+		},
+	})
+}
+
+func (h *userHandler) verifyMFA(ctx context.Context, payload VerifyMFARequest, ip string) (User, authTokens, error) {
+	challenge, err := h.userRepo.GetLoginChallenge(ctx, hashToken(payload.MFAToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, authTokens{}, config.ErrInvalidMFAToken
+		}
+
+		return User{}, authTokens{}, errors.Wrap(err, "GetLoginChallenge error")
+	}
+	if challenge.UsedAt.Valid || challenge.Expired {
+		return User{}, authTokens{}, config.ErrInvalidMFAToken
+	}
+
+	user, err := h.userRepo.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "GetUserByID error")
+	}
+
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// codes are short, so guessing them is throttled like guessing passwords
+	if err := h.loginThrottler.Check(ctx, tx, user.Email, ip); err != nil {
+		return User{}, authTokens{}, err
+	}
+
+	if payload.RecoveryCode != "" {
+		err = h.totpVerifier.VerifyRecoveryCode(ctx, user.ID, payload.RecoveryCode)
+	} else {
+		err = h.totpVerifier.VerifyCode(ctx, user.ID, payload.Code)
+	}
+	if err != nil {
+		if err == config.ErrInvalidMFACode {
+			if err := h.loginThrottler.RecordFailure(ctx, tx, user.Email, ip); err != nil {
+				return User{}, authTokens{}, errors.Wrap(err, "RecordFailure error")
+			}
+
+			if err := tx.Commit(); err != nil {
+				return User{}, authTokens{}, errors.Wrap(err, "Commit error")
+			}
+		}
+
+		return User{}, authTokens{}, err
+	}
+
+	used, err := h.userRepo.UseLoginChallenge(ctx, challenge.ID)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "UseLoginChallenge error")
+	}
+	if !used {
+		return User{}, authTokens{}, config.ErrInvalidMFAToken
+	}
+
+	if err := h.loginThrottler.RecordSuccess(ctx, tx, user.Email); err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "RecordSuccess error")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "Commit error")
+	}
+
+	tokens, err := h.startSession(ctx, user)
+	if err != nil {
+		return User{}, authTokens{}, err
+	}
+
+	return user, tokens, nil
+}
+
+// startLoginChallenge is used instead of starting the session when the user has MFA enabled
+func (h *userHandler) startLoginChallenge(ctx context.Context, user User) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.userRepo.CreateLoginChallenge(ctx, LoginChallenge{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+	}, h.mfaChallengeTTL)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateLoginChallenge error")
+	}
+
+	return token, nil
+}
+
+// generateRecoveryCodes returns the codes formatted like xxxxx-xxxxx, together with their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, errors.Wrap(err, "rand.Read error")
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(buf))[:recoveryCodeSize]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
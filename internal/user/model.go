@@ -1,6 +1,7 @@
 package user
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -15,10 +16,163 @@ type AuthenticateRequest struct {
 	Password string `json:"password" validate:"required,min=5,max=15"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,min=5,max=15,nefield=CurrentPassword"`
+
+	UserID string
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email,min=7,max=50"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=5,max=15"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+
+	UserID string
+}
+
+// VerifyMFARequest completes a login with either a TOTP code or a recovery code
+type VerifyMFARequest struct {
+	MFAToken     string `json:"mfaToken" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,excluded_with=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code,omitempty,max=32"`
+}
+
+type UpdateProfileRequest struct {
+	Name  *string `json:"name" validate:"omitempty,min=5,max=50"`
+	Email *string `json:"email" validate:"omitempty,email,min=7,max=50"`
+
+	UserID string
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type CloseAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+
+	UserID string
+}
+
+type SearchUsersRequest struct {
+	Search string `query:"search" validate:"max=64"`
+	Limit  uint   `query:"limit"`
+	Offset uint   `query:"offset"`
+}
+
+// AdminUserRequest is for the admin requests about a single user
+type AdminUserRequest struct {
+	UserID string `params:"userId" validate:"required,uuid"`
+}
+
+type FreezeUserRequest struct {
+	UserID string `params:"userId" validate:"required,uuid"`
+	Reason string `json:"reason" validate:"required,max=256"`
+}
+
+type UpdateRoleRequest struct {
+	UserID string `params:"userId" validate:"required,uuid"`
+	Role   string `json:"role" validate:"required,oneof=customer support admin"`
+}
+
 type User struct {
 	ID        string    `db:"id"`
 	Email     string    `db:"email"`
 	Name      string    `db:"name"`
 	Password  string    `db:"password"`
+	Role      string    `db:"role"`
+	Tier      string    `db:"tier"`
 	CreatedAt time.Time `db:"created_at"`
+
+	// EmailVerifiedAt is only set once the user proves they own the email.
+	// Unverified users can log in, but can't move money.
+	EmailVerifiedAt sql.NullTime `db:"email_verified_at"`
+
+	// a frozen user can still log in and view their balances, but can't move money
+	FrozenAt     sql.NullTime   `db:"frozen_at"`
+	FrozenReason sql.NullString `db:"frozen_reason"`
+
+	// DeletedAt is set once the account is closed
+	DeletedAt sql.NullTime `db:"deleted_at"`
+}
+
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt.Valid
+}
+
+func (u User) Frozen() bool {
+	return u.FrozenAt.Valid
+}
+
+type Session struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type RefreshToken struct {
+	ID        string       `db:"id"`
+	SessionID string       `db:"session_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+
+	// these are computed by the database, so they're compared against the same clock which set ExpiresAt
+	Expired        bool   `db:"expired"`
+	SessionRevoked bool   `db:"session_revoked"`
+	UserID         string `db:"user_id"`
+}
+
+type PasswordResetToken struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+
+	// Expired is computed by the database, so it's compared against the same clock which set ExpiresAt
+	Expired bool `db:"expired"`
+}
+
+type UserTOTP struct {
+	UserID       string       `db:"user_id"`
+	Secret       string       `db:"secret"`
+	EnabledAt    sql.NullTime `db:"enabled_at"`
+	LastUsedStep int64        `db:"last_used_step"`
+}
+
+type LoginChallenge struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+
+	// Expired is computed by the database, so it's compared against the same clock which set ExpiresAt
+	Expired bool `db:"expired"`
+}
+
+type EmailVerificationToken struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	Email     string       `db:"email"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+
+	// Expired is computed by the database, so it's compared against the same clock which set ExpiresAt
+	Expired bool `db:"expired"`
 }
@@ -0,0 +1,228 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/notifier"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePassword replaces the password of the logged in user. Every other session is logged out.
+func (h *userHandler) ChangePassword(c *fiber.Ctx) error {
+	var payload ChangePasswordRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	user, tokens, err := h.changePassword(c.Context(), payload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Password changed successfully",
+		Data: UserResponse{
+			Email:        user.Email,
+			Name:         user.Name,
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+		},
+	})
+}
+
+func (h *userHandler) changePassword(ctx context.Context, payload ChangePasswordRequest) (User, authTokens, error) {
+	user, err := h.userRepo.GetUserByID(ctx, payload.UserID)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "GetUserByID error")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.CurrentPassword)); err != nil {
+		return User{}, authTokens{}, config.ErrWrongPassword
+	}
+
+	err = h.replacePassword(ctx, nil, user.ID, payload.NewPassword)
+	if err != nil {
+		return User{}, authTokens{}, err
+	}
+
+	// the sessions were all revoked, so the user continues in a new one
+	tokens, err := h.startSession(ctx, user)
+	if err != nil {
+		return User{}, authTokens{}, err
+	}
+
+	return user, tokens, nil
+}
+
+// RequestPasswordReset sends a reset token to the email. It responds the same whether the email is registered or not.
+func (h *userHandler) RequestPasswordReset(c *fiber.Ctx) error {
+	var payload RequestPasswordResetRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.requestPasswordReset(c.Context(), payload); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "If the email is registered, a password reset token has been sent to it",
+	})
+}
+
+func (h *userHandler) requestPasswordReset(ctx context.Context, payload RequestPasswordResetRequest) error {
+	user, err := h.userRepo.GetUserByEmail(ctx, payload.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		return errors.Wrap(err, "GetUserByEmail error")
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = h.userRepo.CreatePasswordResetToken(ctx, PasswordResetToken{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+	}, h.passwordResetTokenTTL)
+	if err != nil {
+		return errors.Wrap(err, "CreatePasswordResetToken error")
+	}
+
+	err = h.notifier.Notify(ctx, notifier.Message{
+		To:      user.Email,
+		Subject: "Reset your PaimonBank password",
+		Body: fmt.Sprintf(
+			"Use this token to reset your password: %s\nIt expires in %s. If you didn't ask for it, you can ignore this message.",
+			token, h.passwordResetTokenTTL,
+		),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Notify error")
+	}
+
+	return nil
+}
+
+// ResetPassword replaces the password using a reset token. Every session of the user is logged out.
+func (h *userHandler) ResetPassword(c *fiber.Ctx) error {
+	var payload ResetPasswordRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.resetPassword(c.Context(), payload); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Password reset successfully",
+	})
+}
+
+func (h *userHandler) resetPassword(ctx context.Context, payload ResetPasswordRequest) error {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// locking the token makes sure it's only used once
+	token, err := h.userRepo.GetPasswordResetTokenForUpdate(ctx, tx, hashToken(payload.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrInvalidResetToken
+		}
+
+		return errors.Wrap(err, "GetPasswordResetTokenForUpdate error")
+	}
+	if token.UsedAt.Valid || token.Expired {
+		return config.ErrInvalidResetToken
+	}
+
+	err = h.userRepo.MarkPasswordResetTokenUsed(ctx, tx, token.ID)
+	if err != nil {
+		return errors.Wrap(err, "MarkPasswordResetTokenUsed error")
+	}
+
+	err = h.replacePassword(ctx, tx, token.UserID, payload.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Commit error")
+	}
+
+	return nil
+}
+
+// replacePassword saves the new password, and revokes every session of the user.
+// If tx is nil, it runs in its own transaction.
+func (h *userHandler) replacePassword(ctx context.Context, tx *sql.Tx, userID, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.saltCost)
+	if err != nil {
+		return err
+	}
+
+	ownTx := tx == nil
+	if ownTx {
+		tx, err = h.trxProvider.NewTransaction(ctx)
+		if err != nil {
+			return errors.Wrap(err, "NewTransaction error")
+		}
+		defer tx.Rollback()
+	}
+
+	err = h.userRepo.UpdatePassword(ctx, tx, userID, string(hashedPassword))
+	if err != nil {
+		return errors.Wrap(err, "UpdatePassword error")
+	}
+
+	err = h.userRepo.RevokeUserSessions(ctx, tx, userID)
+	if err != nil {
+		return errors.Wrap(err, "RevokeUserSessions error")
+	}
+
+	if ownTx {
+		err = tx.Commit()
+		if err != nil {
+			return errors.Wrap(err, "Commit error")
+		}
+	}
+
+	return nil
+}
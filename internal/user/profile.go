@@ -0,0 +1,380 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/notifier"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BalanceChecker checks whether the user still holds money, so the account can't be closed with it
+type BalanceChecker interface {
+	HasOpenBalance(ctx context.Context, tx *sql.Tx, userID string) (bool, error)
+}
+
+// GetProfile returns the profile of the logged in user
+func (h *userHandler) GetProfile(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	ctx := c.Context()
+	user, err := h.userRepo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrUserNotFound
+		}
+
+		return errors.Wrap(err, "GetUserByID error")
+	}
+
+	mfaEnabled, err := h.totpVerifier.IsEnabled(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "success",
+		Data:    newProfileResponse(user, mfaEnabled),
+	})
+}
+
+// UpdateProfile updates the name and email of the logged in user. The name is updated right away,
+// while the new email only replaces the current one after it's verified.
+func (h *userHandler) UpdateProfile(c *fiber.Ctx) error {
+	var payload UpdateProfileRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Context()
+	user, err := h.updateProfile(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	mfaEnabled, err := h.totpVerifier.IsEnabled(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	response := newProfileResponse(user, mfaEnabled)
+	if payload.Email != nil && *payload.Email != user.Email {
+		response.PendingEmail = *payload.Email
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Profile updated successfully",
+		Data:    response,
+	})
+}
+
+func (h *userHandler) updateProfile(ctx context.Context, payload UpdateProfileRequest) (User, error) {
+	user, err := h.userRepo.GetUserByID(ctx, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, config.ErrUserNotFound
+		}
+
+		return User{}, errors.Wrap(err, "GetUserByID error")
+	}
+
+	if payload.Name != nil && *payload.Name != user.Name {
+		err = h.userRepo.UpdateName(ctx, user.ID, *payload.Name)
+		if err != nil {
+			return User{}, errors.Wrap(err, "UpdateName error")
+		}
+		user.Name = *payload.Name
+	}
+
+	if payload.Email != nil && *payload.Email != user.Email {
+		err = h.requestEmailChange(ctx, user, *payload.Email)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	return user, nil
+}
+
+// requestEmailChange sends a verification token to the new email, which proves the user owns it
+func (h *userHandler) requestEmailChange(ctx context.Context, user User, email string) error {
+	exists, err := h.userRepo.EmailExists(ctx, email)
+	if err != nil {
+		return errors.Wrap(err, "EmailExists error")
+	}
+	if exists {
+		return config.ErrCredentialExists
+	}
+
+	return h.sendEmailVerification(ctx, user.ID, email)
+}
+
+// sendEmailVerification sends a token to the email, which sets it as the verified email of the user once used
+func (h *userHandler) sendEmailVerification(ctx context.Context, userID, email string) error {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	token, err := h.createEmailVerificationToken(ctx, tx, userID, email)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Commit error")
+	}
+
+	return h.notifyEmailVerification(ctx, email, token)
+}
+
+// createEmailVerificationToken saves a new verification token of the email, and returns it
+func (h *userHandler) createEmailVerificationToken(ctx context.Context, tx *sql.Tx, userID, email string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.userRepo.CreateEmailVerificationToken(ctx, tx, EmailVerificationToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Email:     email,
+		TokenHash: hashToken(token),
+	}, h.emailVerificationTokenTTL)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateEmailVerificationToken error")
+	}
+
+	return token, nil
+}
+
+func (h *userHandler) notifyEmailVerification(ctx context.Context, email, token string) error {
+	err := h.notifier.Notify(ctx, notifier.Message{
+		To:      email,
+		Subject: "Verify your PaimonBank email",
+		Body: fmt.Sprintf(
+			"Use this token to verify your email: %s\nIt expires in %s. If you didn't ask for it, you can ignore this message.",
+			token, h.emailVerificationTokenTTL,
+		),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Notify error")
+	}
+
+	return nil
+}
+
+// ResendEmailVerification sends a new verification token to the email of the logged in user
+func (h *userHandler) ResendEmailVerification(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	ctx := c.Context()
+	user, err := h.userRepo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrUserNotFound
+		}
+
+		return errors.Wrap(err, "GetUserByID error")
+	}
+	if user.EmailVerified() {
+		return config.ErrEmailAlreadyVerified
+	}
+
+	if err := h.sendEmailVerification(ctx, user.ID, user.Email); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "A verification token has been sent to the email",
+	})
+}
+
+// VerifyEmail verifies the email the token is sent to. If it's a new email, it replaces the current one of the user.
+func (h *userHandler) VerifyEmail(c *fiber.Ctx) error {
+	var payload VerifyEmailRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.verifyEmail(c.Context(), payload); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Email verified successfully",
+	})
+}
+
+func (h *userHandler) verifyEmail(ctx context.Context, payload VerifyEmailRequest) error {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	// locking the token makes sure it's only used once
+	token, err := h.userRepo.GetEmailVerificationTokenForUpdate(ctx, tx, hashToken(payload.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrInvalidEmailToken
+		}
+
+		return errors.Wrap(err, "GetEmailVerificationTokenForUpdate error")
+	}
+	if token.UsedAt.Valid || token.Expired {
+		return config.ErrInvalidEmailToken
+	}
+
+	user, err := h.userRepo.GetUserByIDForUpdate(ctx, tx, token.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// the account has been closed since the token was sent
+			return config.ErrInvalidEmailToken
+		}
+
+		return errors.Wrap(err, "GetUserByIDForUpdate error")
+	}
+
+	if token.Email != user.Email {
+		// the email may have been registered by someone else since the token was sent
+		exists, err := h.userRepo.EmailExists(ctx, token.Email)
+		if err != nil {
+			return errors.Wrap(err, "EmailExists error")
+		}
+		if exists {
+			return config.ErrCredentialExists
+		}
+	}
+
+	err = h.userRepo.MarkEmailVerificationTokenUsed(ctx, tx, token.ID)
+	if err != nil {
+		return errors.Wrap(err, "MarkEmailVerificationTokenUsed error")
+	}
+
+	err = h.userRepo.VerifyEmail(ctx, tx, user.ID, token.Email)
+	if err != nil {
+		return errors.Wrap(err, "VerifyEmail error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Commit error")
+	}
+
+	return nil
+}
+
+// CloseAccount closes the account of the logged in user, and logs out every session of it.
+// It's refused while the user still has money in any currency.
+func (h *userHandler) CloseAccount(c *fiber.Ctx) error {
+	var payload CloseAccountRequest
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+	payload.UserID = claims.UserID
+
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.closeAccount(c.Context(), payload); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Account closed successfully",
+	})
+}
+
+func (h *userHandler) closeAccount(ctx context.Context, payload CloseAccountRequest) error {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	user, err := h.userRepo.GetUserByIDForUpdate(ctx, tx, payload.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrUserNotFound
+		}
+
+		return errors.Wrap(err, "GetUserByIDForUpdate error")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
+		return config.ErrWrongPassword
+	}
+
+	hasBalance, err := h.balanceChecker.HasOpenBalance(ctx, tx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "HasOpenBalance error")
+	}
+	if hasBalance {
+		return config.ErrAccountHasBalance
+	}
+
+	err = h.userRepo.SoftDeleteUser(ctx, tx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "SoftDeleteUser error")
+	}
+
+	err = h.userRepo.RevokeUserSessions(ctx, tx, user.ID)
+	if err != nil {
+		return errors.Wrap(err, "RevokeUserSessions error")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Commit error")
+	}
+
+	return nil
+}
+
+func newProfileResponse(user User, mfaEnabled bool) ProfileResponse {
+	return ProfileResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		EmailVerified: user.EmailVerified(),
+		MFAEnabled:    mfaEnabled,
+		CreatedAt:     uint64(user.CreatedAt.UnixMilli()),
+	}
+}
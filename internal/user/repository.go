@@ -2,7 +2,10 @@ package user
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,12 +17,17 @@ func NewUserRepo(db *sqlx.DB) UserRepo {
 	return UserRepo{db: db}
 }
 
-func (r *UserRepo) CreateUser(ctx context.Context, user User) error {
+// txx wraps the transaction, so its rows can be scanned into structs like the ones read from db
+func (r *UserRepo) txx(tx *sql.Tx) *sqlx.Tx {
+	return &sqlx.Tx{Tx: tx, Mapper: r.db.Mapper}
+}
+
+func (r *UserRepo) CreateUser(ctx context.Context, tx *sql.Tx, user User) error {
 	query := `
 		INSERT INTO users
-			(id, email, name, password)
+			(id, email, name, password, role)
 		VALUES
-			(:id, :email, :name, :password)
+			(:id, :email, :name, :password, :role)
 	`
 
 	updatedQuery, args, err := sqlx.Named(query, user)
@@ -28,7 +36,7 @@ func (r *UserRepo) CreateUser(ctx context.Context, user User) error {
 	}
 
 	// since we won't be using the returned data, leave it blank
-	_, err = r.db.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, updatedQuery), args...)
+	_, err = tx.ExecContext(ctx, sqlx.Rebind(sqlx.DOLLAR, updatedQuery), args...)
 	if err != nil {
 		return err
 	}
@@ -44,11 +52,17 @@ func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (User, erro
 			id,
 			email,
 			name,
-			password
+			password,
+			role,
+			tier,
+			email_verified_at,
+			frozen_at,
+			frozen_reason,
+			created_at
 		FROM
 			users
 		WHERE
-			email = $1
+			email = $1 AND deleted_at IS NULL
 		LIMIT 1
 	`
 
@@ -68,7 +82,94 @@ func (r *UserRepo) GetUserByID(ctx context.Context, id string) (User, error) {
 			id,
 			email,
 			name,
-			password
+			password,
+			role,
+			tier,
+			email_verified_at,
+			frozen_at,
+			frozen_reason,
+			created_at
+		FROM
+			users
+		WHERE
+			id = $1 AND deleted_at IS NULL
+		LIMIT 1
+	`
+
+	err := r.db.GetContext(ctx, &result, query, id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// EmailExists checks every user, including the closed accounts which still hold their email
+func (r *UserRepo) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM users WHERE email = $1
+		)
+	`
+
+	err := r.db.GetContext(ctx, &exists, query, email)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// GetUserByIDForUpdate returns the user, and locks it until tx ends
+func (r *UserRepo) GetUserByIDForUpdate(ctx context.Context, tx *sql.Tx, id string) (User, error) {
+	var result User
+
+	query := `
+		SELECT
+			id,
+			email,
+			name,
+			password,
+			role,
+			tier,
+			email_verified_at,
+			frozen_at,
+			frozen_reason,
+			created_at
+		FROM
+			users
+		WHERE
+			id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, id)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// GetUserByIDIncludingClosed returns the user even if the account has been closed, so it can still be looked into
+func (r *UserRepo) GetUserByIDIncludingClosed(ctx context.Context, id string) (User, error) {
+	var result User
+
+	query := `
+		SELECT
+			id,
+			email,
+			name,
+			password,
+			role,
+			tier,
+			email_verified_at,
+			frozen_at,
+			frozen_reason,
+			deleted_at,
+			created_at
 		FROM
 			users
 		WHERE
@@ -83,3 +184,669 @@ func (r *UserRepo) GetUserByID(ctx context.Context, id string) (User, error) {
 
 	return result, nil
 }
+
+// SearchUsers finds the users whose ID is the search, or whose email or name contains it.
+// Closed accounts are included.
+func (r *UserRepo) SearchUsers(ctx context.Context, payload SearchUsersRequest) ([]User, error) {
+	var result []User
+
+	query := `
+		SELECT
+			id,
+			email,
+			name,
+			role,
+			tier,
+			email_verified_at,
+			frozen_at,
+			frozen_reason,
+			deleted_at,
+			created_at
+		FROM
+			users
+		WHERE
+			$1 = ''
+			OR id = $1
+			OR email ILIKE '%' || $1 || '%'
+			OR name ILIKE '%' || $1 || '%'
+		ORDER BY
+			created_at DESC, id ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	err := r.db.SelectContext(ctx, &result, query, payload.Search, limit, payload.Offset)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// FreezeUser stops the user from moving money. The reason is kept for the other staff members to see.
+func (r *UserRepo) FreezeUser(ctx context.Context, userID, reason string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			frozen_at = NOW(),
+			frozen_reason = $2,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, reason)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UnfreezeUser(ctx context.Context, userID string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			frozen_at = NULL,
+			frozen_reason = NULL,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UpdateRole(ctx context.Context, tx *sql.Tx, userID, role string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			role = $2,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UpdateName(ctx context.Context, userID, name string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			name = $2,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// VerifyEmail sets the email of the user, and marks it as verified
+func (r *UserRepo) VerifyEmail(ctx context.Context, tx *sql.Tx, userID, email string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			email = $2,
+			email_verified_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SoftDeleteUser closes the account. The row is kept, so its history can still be traced.
+func (r *UserRepo) SoftDeleteUser(ctx context.Context, tx *sql.Tx, userID string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			deleted_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateEmailVerificationToken(ctx context.Context, tx *sql.Tx, token EmailVerificationToken, ttl time.Duration) error {
+	query := `
+		INSERT INTO email_verification_tokens
+			(id, user_id, email, token_hash, expires_at)
+		VALUES
+			($1, $2, $3, $4, NOW() + make_interval(secs => $5))
+	`
+
+	_, err := tx.ExecContext(ctx, query, token.ID, token.UserID, token.Email, token.TokenHash, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetEmailVerificationTokenForUpdate returns the token, and locks it until tx ends
+func (r *UserRepo) GetEmailVerificationTokenForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (EmailVerificationToken, error) {
+	var result EmailVerificationToken
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			email,
+			token_hash,
+			expires_at,
+			used_at,
+			expires_at < NOW() AS expired
+		FROM
+			email_verification_tokens
+		WHERE
+			token_hash = $1
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, tokenHash)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *UserRepo) MarkEmailVerificationTokenUsed(ctx context.Context, tx *sql.Tx, id string) error {
+	query := `
+		UPDATE
+			email_verification_tokens
+		SET
+			used_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateSession(ctx context.Context, tx *sql.Tx, session Session) error {
+	query := `
+		INSERT INTO user_sessions
+			(id, user_id)
+		VALUES
+			($1, $2)
+	`
+
+	_, err := tx.ExecContext(ctx, query, session.ID, session.UserID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsSessionActive reports whether the session exists and hasn't been revoked
+func (r *UserRepo) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+
+	query := `
+		SELECT
+			revoked_at IS NULL
+		FROM
+			user_sessions
+		WHERE
+			id = $1
+	`
+
+	err := r.db.GetContext(ctx, &active, query, sessionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return active, nil
+}
+
+func (r *UserRepo) RevokeSession(ctx context.Context, sessionID string) error {
+	query := `
+		UPDATE
+			user_sessions
+		SET
+			revoked_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateRefreshToken(ctx context.Context, tx *sql.Tx, token RefreshToken, ttl time.Duration) error {
+	query := `
+		INSERT INTO refresh_tokens
+			(id, session_id, token_hash, expires_at)
+		VALUES
+			($1, $2, $3, NOW() + make_interval(secs => $4))
+	`
+
+	_, err := tx.ExecContext(ctx, query, token.ID, token.SessionID, token.TokenHash, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRefreshTokenForUpdate returns the token with its session, and locks it until tx ends
+// so it can only be rotated once
+func (r *UserRepo) GetRefreshTokenForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (RefreshToken, error) {
+	var result RefreshToken
+
+	query := `
+		SELECT
+			rt.id,
+			rt.session_id,
+			rt.token_hash,
+			rt.expires_at,
+			rt.used_at,
+			rt.expires_at < NOW() AS expired,
+			us.revoked_at IS NOT NULL AS session_revoked,
+			us.user_id
+		FROM
+			refresh_tokens rt
+			JOIN user_sessions us ON us.id = rt.session_id
+		WHERE
+			rt.token_hash = $1
+		FOR UPDATE OF rt
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, tokenHash)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *UserRepo) MarkRefreshTokenUsed(ctx context.Context, tx *sql.Tx, id string) error {
+	query := `
+		UPDATE
+			refresh_tokens
+		SET
+			used_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, tx *sql.Tx, userID, password string) error {
+	query := `
+		UPDATE
+			users
+		SET
+			password = $2,
+			updated_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID, password)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserSessions logs the user out of every session
+func (r *UserRepo) RevokeUserSessions(ctx context.Context, tx *sql.Tx, userID string) error {
+	query := `
+		UPDATE
+			user_sessions
+		SET
+			revoked_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			user_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreatePasswordResetToken(ctx context.Context, token PasswordResetToken, ttl time.Duration) error {
+	query := `
+		INSERT INTO password_reset_tokens
+			(id, user_id, token_hash, expires_at)
+		VALUES
+			($1, $2, $3, NOW() + make_interval(secs => $4))
+	`
+
+	_, err := r.db.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPasswordResetTokenForUpdate returns the token, and locks it until tx ends
+func (r *UserRepo) GetPasswordResetTokenForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (PasswordResetToken, error) {
+	var result PasswordResetToken
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			token_hash,
+			expires_at,
+			used_at,
+			expires_at < NOW() AS expired
+		FROM
+			password_reset_tokens
+		WHERE
+			token_hash = $1
+		FOR UPDATE
+	`
+
+	err := sqlx.GetContext(ctx, r.txx(tx), &result, query, tokenHash)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *UserRepo) MarkPasswordResetTokenUsed(ctx context.Context, tx *sql.Tx, id string) error {
+	query := `
+		UPDATE
+			password_reset_tokens
+		SET
+			used_at = NOW()
+		WHERE
+			id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpsertPendingTOTP starts a new enrollment, replacing the unconfirmed one.
+// It returns false if the user already has TOTP enabled.
+func (r *UserRepo) UpsertPendingTOTP(ctx context.Context, userID, secret string) (bool, error) {
+	query := `
+		INSERT INTO user_totp
+			(user_id, secret)
+		VALUES
+			($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			last_used_step = 0,
+			updated_at = NOW()
+		WHERE
+			user_totp.enabled_at IS NULL
+	`
+
+	res, err := r.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *UserRepo) GetTOTP(ctx context.Context, userID string) (UserTOTP, error) {
+	var result UserTOTP
+
+	query := `
+		SELECT
+			user_id,
+			secret,
+			enabled_at,
+			last_used_step
+		FROM
+			user_totp
+		WHERE
+			user_id = $1
+	`
+
+	err := r.db.GetContext(ctx, &result, query, userID)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// UseTOTPStep records the step of the accepted code. It returns false if a code
+// of the same or a later step has already been used.
+func (r *UserRepo) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `
+		UPDATE
+			user_totp
+		SET
+			last_used_step = $2,
+			updated_at = NOW()
+		WHERE
+			user_id = $1 AND last_used_step < $2
+	`
+
+	res, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, tx *sql.Tx, userID string) error {
+	query := `
+		UPDATE
+			user_totp
+		SET
+			enabled_at = NOW(),
+			updated_at = NOW()
+		WHERE
+			user_id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes removes the previous recovery codes of the user, and stores the new ones
+func (r *UserRepo) ReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, codeHashes []string) error {
+	deleteQuery := `
+		DELETE FROM
+			mfa_recovery_codes
+		WHERE
+			user_id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, deleteQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `
+		INSERT INTO mfa_recovery_codes
+			(id, user_id, code_hash)
+		VALUES
+			($1, $2, $3)
+	`
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.ExecContext(ctx, insertQuery, uuid.NewString(), userID, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the code used. It returns false if the code is not found or has already been used.
+func (r *UserRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	query := `
+		UPDATE
+			mfa_recovery_codes
+		SET
+			used_at = NOW()
+		WHERE
+			user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	res, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *UserRepo) CreateLoginChallenge(ctx context.Context, challenge LoginChallenge, ttl time.Duration) error {
+	query := `
+		INSERT INTO login_challenges
+			(id, user_id, token_hash, expires_at)
+		VALUES
+			($1, $2, $3, NOW() + make_interval(secs => $4))
+	`
+
+	_, err := r.db.ExecContext(ctx, query, challenge.ID, challenge.UserID, challenge.TokenHash, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) GetLoginChallenge(ctx context.Context, tokenHash string) (LoginChallenge, error) {
+	var result LoginChallenge
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			token_hash,
+			expires_at,
+			used_at,
+			expires_at < NOW() AS expired
+		FROM
+			login_challenges
+		WHERE
+			token_hash = $1
+	`
+
+	err := r.db.GetContext(ctx, &result, query, tokenHash)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// UseLoginChallenge marks the challenge used. It returns false if it has already been used.
+func (r *UserRepo) UseLoginChallenge(ctx context.Context, id string) (bool, error) {
+	query := `
+		UPDATE
+			login_challenges
+		SET
+			used_at = NOW()
+		WHERE
+			id = $1 AND used_at IS NULL
+	`
+
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
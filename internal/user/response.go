@@ -1,7 +1,49 @@
 package user
 
 type UserResponse struct {
-	Email       string `json:"email"`
-	Name        string `json:"name"`
-	AccessToken string `json:"accessToken"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// MFAChallengeResponse is given instead of the tokens when the login needs the second factor
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// AdminUserResponse is the user as seen by the support staff and admins
+type AdminUserResponse struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	Role          string `json:"role"`
+	Tier          string `json:"tier"`
+	EmailVerified bool   `json:"emailVerified"`
+	Frozen        bool   `json:"frozen"`
+	FrozenReason  string `json:"frozenReason,omitempty"`
+	Closed        bool   `json:"closed"`
+	CreatedAt     uint64 `json:"createdAt"`
+}
+
+type ProfileResponse struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	EmailVerified bool   `json:"emailVerified"`
+	MFAEnabled    bool   `json:"mfaEnabled"`
+	CreatedAt     uint64 `json:"createdAt"`
+
+	// PendingEmail is the email waiting to be verified, before it replaces the current one
+	PendingEmail string `json:"pendingEmail,omitempty"`
 }
@@ -0,0 +1,190 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/model"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/jwt"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type authTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// RefreshToken exchanges a refresh token for a new access token. The refresh token is rotated,
+// so the one sent can't be used again.
+func (h *userHandler) RefreshToken(c *fiber.Ctx) error {
+	var payload RefreshTokenRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return errors.Wrap(config.ErrMalformedRequest, err.Error())
+	}
+
+	if err := validation.Validate(payload); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	user, tokens, err := h.rotateRefreshToken(c.Context(), payload.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "Token refreshed successfully",
+		Data: UserResponse{
+			Email:        user.Email,
+			Name:         user.Name,
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+		},
+	})
+}
+
+// Logout revokes the session of the access token, together with its refresh token
+func (h *userHandler) Logout(c *fiber.Ctx) error {
+	claims, err := jwt.GetLoggedInUser(c)
+	if err != nil {
+		return config.ErrRequestForbidden
+	}
+
+	err = h.userRepo.RevokeSession(c.Context(), claims.SessionID)
+	if err != nil {
+		return errors.Wrap(err, "RevokeSession error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(model.DataResponse{
+		Message: "User logged out successfully",
+	})
+}
+
+// startSession starts a new login session of the user, and issues its first tokens
+func (h *userHandler) startSession(ctx context.Context, user User) (authTokens, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return authTokens{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	session := Session{
+		ID:     uuid.NewString(),
+		UserID: user.ID,
+	}
+	err = h.userRepo.CreateSession(ctx, tx, session)
+	if err != nil {
+		return authTokens{}, errors.Wrap(err, "CreateSession error")
+	}
+
+	tokens, err := h.issueTokens(ctx, tx, user, session.ID)
+	if err != nil {
+		return authTokens{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return authTokens{}, errors.Wrap(err, "Commit error")
+	}
+
+	return tokens, nil
+}
+
+func (h *userHandler) rotateRefreshToken(ctx context.Context, refreshToken string) (User, authTokens, error) {
+	tx, err := h.trxProvider.NewTransaction(ctx)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "NewTransaction error")
+	}
+	defer tx.Rollback()
+
+	token, err := h.userRepo.GetRefreshTokenForUpdate(ctx, tx, hashToken(refreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, authTokens{}, config.ErrInvalidRefreshToken
+		}
+
+		return User{}, authTokens{}, errors.Wrap(err, "GetRefreshTokenForUpdate error")
+	}
+
+	// a rotated token being used again means it has leaked, so the whole session is ended
+	if token.UsedAt.Valid {
+		if err := h.userRepo.RevokeSession(ctx, token.SessionID); err != nil {
+			return User{}, authTokens{}, errors.Wrap(err, "RevokeSession error")
+		}
+
+		return User{}, authTokens{}, config.ErrInvalidRefreshToken
+	}
+	if token.Expired || token.SessionRevoked {
+		return User{}, authTokens{}, config.ErrInvalidRefreshToken
+	}
+
+	err = h.userRepo.MarkRefreshTokenUsed(ctx, tx, token.ID)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "MarkRefreshTokenUsed error")
+	}
+
+	user, err := h.userRepo.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "GetUserByID error")
+	}
+
+	tokens, err := h.issueTokens(ctx, tx, user, token.SessionID)
+	if err != nil {
+		return User{}, authTokens{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return User{}, authTokens{}, errors.Wrap(err, "Commit error")
+	}
+
+	return user, tokens, nil
+}
+
+func (h *userHandler) issueTokens(ctx context.Context, tx *sql.Tx, user User, sessionID string) (authTokens, error) {
+	accessToken, err := h.generateAccessTokenFromUser(user, sessionID)
+	if err != nil {
+		return authTokens{}, errors.Wrap(err, "generateAccessToken error")
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return authTokens{}, err
+	}
+
+	err = h.userRepo.CreateRefreshToken(ctx, tx, RefreshToken{
+		ID:        uuid.NewString(),
+		SessionID: sessionID,
+		TokenHash: hashToken(refreshToken),
+	}, h.refreshTokenTTL)
+	if err != nil {
+		return authTokens{}, errors.Wrap(err, "CreateRefreshToken error")
+	}
+
+	return authTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// generateOpaqueToken returns an opaque random token, e.g. a refresh token. Only its hash is stored.
+func generateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "rand.Read error")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
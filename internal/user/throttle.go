@@ -0,0 +1,198 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/pkg/errors"
+)
+
+// LoginAttempt is the failed logins of a single key, e.g. an account or an IP address
+type LoginAttempt struct {
+	Key          string    `db:"attempt_key"`
+	Failures     int       `db:"failures"`
+	LastFailedAt time.Time `db:"last_failed_at"`
+	LockedUntil  time.Time `db:"locked_until"`
+
+	// Now is the database clock, which every other time is set by
+	Now time.Time `db:"now"`
+}
+
+// LoginAttemptStore keeps the attempts. Every method runs in the transaction of the login,
+// which holds the attempts locked from the check until the result is recorded.
+type LoginAttemptStore interface {
+	// GetForUpdate returns the attempt of the key, and locks it. A key without failures returns a zero attempt.
+	GetForUpdate(ctx context.Context, tx *sql.Tx, key string) (LoginAttempt, error)
+	// RecordFailure counts a failure of the key. Failures older than window are forgotten.
+	RecordFailure(ctx context.Context, tx *sql.Tx, key string, window time.Duration) (LoginAttempt, error)
+	// Lock blocks the key for the duration, and resets its failures
+	Lock(ctx context.Context, tx *sql.Tx, key string, duration time.Duration) error
+	Reset(ctx context.Context, tx *sql.Tx, key string) error
+}
+
+type LoginThrottlerConfig struct {
+	Store       LoginAttemptStore
+	AuditLogger AuditLogger
+
+	// MaxAccountFailures and MaxIPFailures are how many failures lock the account or the IP address
+	MaxAccountFailures int
+	MaxIPFailures      int
+	// BaseDelay is the wait after the first failure, which doubles on every following failure
+	BaseDelay time.Duration
+	// FailureWindow is how long a failure is remembered
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+}
+
+// LoginThrottler slows down and then locks out repeated failed logins, both per account and per IP address
+type LoginThrottler struct {
+	cfg LoginThrottlerConfig
+}
+
+func NewLoginThrottler(cfg LoginThrottlerConfig) LoginThrottler {
+	return LoginThrottler{cfg: cfg}
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + email
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func stepUpAttemptKey(userID string) string {
+	return "stepup:" + userID
+}
+
+// Check returns an error if the account or the IP address has to wait before trying again.
+// The attempts stay locked until tx ends, so concurrent logins can't all pass the check before
+// any of their failures is recorded. The result has to be recorded in the same transaction.
+func (t *LoginThrottler) Check(ctx context.Context, tx *sql.Tx, email, ip string) error {
+	// the account is always locked before the IP address, so concurrent logins can't deadlock
+	for _, key := range []string{accountAttemptKey(email), ipAttemptKey(ip)} {
+		if err := t.check(ctx, tx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure counts the failed login, and locks the account or the IP address once they fail too often
+func (t *LoginThrottler) RecordFailure(ctx context.Context, tx *sql.Tx, email, ip string) error {
+	err := t.recordFailure(ctx, tx, accountAttemptKey(email), t.cfg.MaxAccountFailures, ip, AuditEventLoginLockout, "failed logins")
+	if err != nil {
+		return err
+	}
+
+	return t.recordFailure(ctx, tx, ipAttemptKey(ip), t.cfg.MaxIPFailures, ip, AuditEventLoginLockout, "failed logins")
+}
+
+// RecordSuccess forgets the failures of the account. The IP address keeps them,
+// so logging into one account doesn't allow guessing the others.
+func (t *LoginThrottler) RecordSuccess(ctx context.Context, tx *sql.Tx, email string) error {
+	return t.cfg.Store.Reset(ctx, tx, accountAttemptKey(email))
+}
+
+// CheckStepUp returns an error if the user has to wait before trying another step-up code.
+// Like Check, the attempts stay locked until tx ends.
+func (t *LoginThrottler) CheckStepUp(ctx context.Context, tx *sql.Tx, userID string) error {
+	return t.check(ctx, tx, stepUpAttemptKey(userID))
+}
+
+// RecordStepUpFailure counts the wrong step-up code, and locks the step-up of the user
+// once it fails as often as an account may fail to log in
+func (t *LoginThrottler) RecordStepUpFailure(ctx context.Context, tx *sql.Tx, userID, ip string) error {
+	return t.recordFailure(ctx, tx, stepUpAttemptKey(userID), t.cfg.MaxAccountFailures, ip, AuditEventStepUpLockout, "failed step-up codes")
+}
+
+func (t *LoginThrottler) RecordStepUpSuccess(ctx context.Context, tx *sql.Tx, userID string) error {
+	return t.cfg.Store.Reset(ctx, tx, stepUpAttemptKey(userID))
+}
+
+func (t *LoginThrottler) check(ctx context.Context, tx *sql.Tx, key string) error {
+	attempt, err := t.cfg.Store.GetForUpdate(ctx, tx, key)
+	if err != nil {
+		return errors.Wrap(err, "LoginAttemptStore.GetForUpdate error")
+	}
+
+	if wait := t.retryAfter(attempt); wait > 0 {
+		return newTooManyLoginAttemptsError(wait)
+	}
+
+	return nil
+}
+
+func (t *LoginThrottler) recordFailure(ctx context.Context, tx *sql.Tx, key string, maxFailures int, ip, event, failures string) error {
+	attempt, err := t.cfg.Store.RecordFailure(ctx, tx, key, t.cfg.FailureWindow)
+	if err != nil {
+		return errors.Wrap(err, "LoginAttemptStore.RecordFailure error")
+	}
+
+	if maxFailures <= 0 || attempt.Failures < maxFailures {
+		return nil
+	}
+
+	err = t.cfg.Store.Lock(ctx, tx, key, t.cfg.LockoutDuration)
+	if err != nil {
+		return errors.Wrap(err, "LoginAttemptStore.Lock error")
+	}
+
+	err = t.cfg.AuditLogger.Log(ctx, AuditLog{
+		Event:     event,
+		Subject:   key,
+		IPAddress: ip,
+		Detail:    fmt.Sprintf("locked for %s after %d %s", t.cfg.LockoutDuration, attempt.Failures, failures),
+	})
+	if err != nil {
+		return errors.Wrap(err, "AuditLogger.Log error")
+	}
+
+	return nil
+}
+
+func (t *LoginThrottler) retryAfter(attempt LoginAttempt) time.Duration {
+	if attempt.LockedUntil.After(attempt.Now) {
+		return attempt.LockedUntil.Sub(attempt.Now)
+	}
+
+	if attempt.Failures == 0 || t.cfg.BaseDelay <= 0 {
+		return 0
+	}
+
+	// the delay doubles on every failure, but never exceeds the lockout
+	delay := time.Duration(float64(t.cfg.BaseDelay) * math.Pow(2, float64(attempt.Failures-1)))
+	if delay > t.cfg.LockoutDuration || delay <= 0 {
+		delay = t.cfg.LockoutDuration
+	}
+
+	return attempt.LastFailedAt.Add(delay).Sub(attempt.Now)
+}
+
+// ThrottledLoginError tells how long to wait before trying to log in again
+type ThrottledLoginError struct {
+	RetryAfter time.Duration
+}
+
+func newTooManyLoginAttemptsError(wait time.Duration) error {
+	return &ThrottledLoginError{RetryAfter: wait}
+}
+
+func (e *ThrottledLoginError) Error() string {
+	return config.ErrTooManyLoginAttempts.Error()
+}
+
+// Unwrap lets the error handler respond with the status of config.ErrTooManyLoginAttempts
+func (e *ThrottledLoginError) Unwrap() error {
+	return config.ErrTooManyLoginAttempts
+}
+
+// RetryAfterSeconds is the value of the Retry-After header
+func (e *ThrottledLoginError) RetryAfterSeconds() string {
+	return fmt.Sprint(int(math.Ceil(e.RetryAfter.Seconds())))
+}
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/ahmadnaufal/openidea-paimonbank/internal/config"
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/totp"
+	"github.com/pkg/errors"
+)
+
+// totpSkew is how many steps around the current one are accepted, to allow for clock drift
+const totpSkew = 1
+
+// TOTPVerifier checks the second factor of a user. Other packages use it for step-up verification.
+type TOTPVerifier struct {
+	userRepo *UserRepo
+}
+
+func NewTOTPVerifier(userRepo *UserRepo) TOTPVerifier {
+	return TOTPVerifier{userRepo: userRepo}
+}
+
+func (v *TOTPVerifier) IsEnabled(ctx context.Context, userID string) (bool, error) {
+	userTOTP, err := v.userRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "GetTOTP error")
+	}
+
+	return userTOTP.EnabledAt.Valid, nil
+}
+
+// VerifyCode accepts a TOTP code of the user. Each code is only accepted once.
+func (v *TOTPVerifier) VerifyCode(ctx context.Context, userID, code string) error {
+	userTOTP, err := v.userRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return config.ErrMFANotEnabled
+		}
+
+		return errors.Wrap(err, "GetTOTP error")
+	}
+	if !userTOTP.EnabledAt.Valid {
+		return config.ErrMFANotEnabled
+	}
+
+	return v.useCode(ctx, userTOTP, code)
+}
+
+// VerifyRecoveryCode accepts one of the user's unused recovery codes, and uses it up
+func (v *TOTPVerifier) VerifyRecoveryCode(ctx context.Context, userID, code string) error {
+	used, err := v.userRepo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return errors.Wrap(err, "UseRecoveryCode error")
+	}
+	if !used {
+		return config.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (v *TOTPVerifier) useCode(ctx context.Context, userTOTP UserTOTP, code string) error {
+	step, ok := totp.Validate(userTOTP.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return config.ErrInvalidMFACode
+	}
+
+	// a code which has been used, e.g. to log in, can't be replayed
+	used, err := v.userRepo.UseTOTPStep(ctx, userTOTP.UserID, step)
+	if err != nil {
+		return errors.Wrap(err, "UseTOTPStep error")
+	}
+	if !used {
+		return config.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
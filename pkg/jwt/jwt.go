@@ -1,7 +1,8 @@
 package jwt
 
 import (
-	"encoding/base64"
+	"context"
+	"fmt"
 
 	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/fiber/v2"
@@ -9,55 +10,125 @@ import (
 	"github.com/pkg/errors"
 )
 
-var defaultSigningMethod *jwt.SigningMethodHMAC = jwt.SigningMethodHS256
+// SessionValidator checks whether the session a token is issued for is still active
+type SessionValidator interface {
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
 
 type JWTProvider struct {
-	privateKey []byte
+	activeKey        Key
+	keys             map[string]Key
+	sessionValidator SessionValidator
 }
 
-func NewJWTProvider(privateKey string) JWTProvider {
-	privateKeyDecoded, _ := base64.StdEncoding.DecodeString(privateKey)
+// NewJWTProvider signs the tokens with the active key, and verifies them with any of the keys by their kid.
+// Tokens without a kid are only verified with the key whose ID is empty.
+func NewJWTProvider(activeKeyID string, keys ...Key) (JWTProvider, error) {
+	provider := JWTProvider{
+		keys: map[string]Key{},
+	}
 
-	return JWTProvider{
-		privateKey: privateKeyDecoded,
+	for _, key := range keys {
+		if _, exists := provider.keys[key.ID]; exists {
+			return JWTProvider{}, fmt.Errorf("key %s is duplicated", key.ID)
+		}
+		if key.signingMethod() == nil {
+			return JWTProvider{}, fmt.Errorf("key %s has an unsupported algorithm %s", key.ID, key.Algorithm)
+		}
+		provider.keys[key.ID] = key
+	}
+
+	activeKey, exists := provider.keys[activeKeyID]
+	if !exists {
+		return JWTProvider{}, fmt.Errorf("active key %s is not found", activeKeyID)
+	}
+	if !activeKey.CanSign() {
+		return JWTProvider{}, fmt.Errorf("active key %s has no private key", activeKeyID)
 	}
+	provider.activeKey = activeKey
+
+	return provider, nil
+}
+
+// SetSessionValidator makes the middlewares reject tokens whose session has been revoked
+func (p *JWTProvider) SetSessionValidator(validator SessionValidator) {
+	p.sessionValidator = validator
 }
 
 func (p *JWTProvider) GenerateToken(payload jwt.MapClaims) (string, error) {
-	token, err := jwt.NewWithClaims(defaultSigningMethod, payload).SignedString(p.privateKey)
+	token := jwt.NewWithClaims(p.activeKey.signingMethod(), payload)
+	if p.activeKey.ID != "" {
+		token.Header["kid"] = p.activeKey.ID
+	}
+
+	signedToken, err := token.SignedString(p.activeKey.signingKey)
 	if err != nil {
 		return "", errors.Wrap(err, "error returning signed string")
 	}
 
-	return token, nil
+	return signedToken, nil
+}
+
+// keyFunc finds the key the token is verified with. The token's algorithm must be the key's,
+// so e.g. a public RSA key can't be used as an HMAC secret.
+func (p *JWTProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, exists := p.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	return key.verifyingKey, nil
 }
 
 func (p *JWTProvider) MiddlewareWithPublic() fiber.Handler {
 	return jwtware.New(jwtware.Config{
 		ContextKey: "user",
 		Claims:     jwt.MapClaims{},
-		SigningKey: jwtware.SigningKey{
-			JWTAlg: jwtware.HS256,
-			Key:    p.privateKey,
-		},
+		KeyFunc:    p.keyFunc,
 		Filter: func(c *fiber.Ctx) bool {
 			// only filter if there's userOnly
 			return !c.QueryBool("userOnly", false)
 		},
+		SuccessHandler: p.validateSession,
 	})
 }
 
 func (p *JWTProvider) Middleware() fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		ContextKey: "user",
-		Claims:     jwt.MapClaims{},
-		SigningKey: jwtware.SigningKey{
-			JWTAlg: jwtware.HS256,
-			Key:    p.privateKey,
-		},
+		ContextKey:     "user",
+		Claims:         jwt.MapClaims{},
+		KeyFunc:        p.keyFunc,
+		SuccessHandler: p.validateSession,
 	})
 }
 
+// validateSession rejects a valid token if its session has been revoked, e.g. by logging out
+func (p *JWTProvider) validateSession(c *fiber.Ctx) error {
+	if p.sessionValidator == nil {
+		return c.Next()
+	}
+
+	claims, err := GetLoggedInUser(c)
+	if err != nil || claims.SessionID == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired JWT")
+	}
+
+	active, err := p.sessionValidator.IsSessionActive(c.Context(), claims.SessionID)
+	if err != nil {
+		return errors.Wrap(err, "IsSessionActive error")
+	}
+	if !active {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired JWT")
+	}
+
+	return c.Next()
+}
+
 func GetLoggedInUser(c *fiber.Ctx) (JWTUser, error) {
 	jwtUser := JWTUser{}
 
@@ -71,9 +142,25 @@ func GetLoggedInUser(c *fiber.Ctx) (JWTUser, error) {
 		return jwtUser, errors.New("unable to get logged in user data")
 	}
 
-	jwtUser.UserID = claims["userId"].(string)
-	jwtUser.Name = claims["name"].(string)
-	jwtUser.Email = claims["email"].(string)
+	for key, target := range map[string]*string{
+		"userId": &jwtUser.UserID,
+		"name":   &jwtUser.Name,
+		"email":  &jwtUser.Email,
+		"sid":    &jwtUser.SessionID,
+		"jti":    &jwtUser.TokenID,
+	} {
+		value, ok := claims[key].(string)
+		if !ok {
+			return JWTUser{}, fmt.Errorf("invalid %s claim in logged in user data", key)
+		}
+		*target = value
+	}
+
+	// tokens issued before roles existed are only ever the customers'
+	jwtUser.Role, _ = claims["role"].(string)
+	if jwtUser.Role == "" {
+		jwtUser.Role = RoleCustomer
+	}
 
 	return jwtUser, nil
 }
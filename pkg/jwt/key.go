@@ -0,0 +1,118 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Key is a key tokens are signed or verified with, identified by its kid.
+// A key without a signing key can only verify tokens, e.g. a retiring key which private key is gone.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	signingKey   interface{}
+	verifyingKey interface{}
+}
+
+// NewHMACKey creates an HS256 key from the base64 encoded secret
+func NewHMACKey(id, secret string) (Key, error) {
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return Key{}, errors.Wrap(err, "secret is not valid base64")
+	}
+	if len(decoded) == 0 {
+		return Key{}, errors.New("secret is empty")
+	}
+
+	return Key{
+		ID:           id,
+		Algorithm:    AlgorithmHS256,
+		signingKey:   decoded,
+		verifyingKey: decoded,
+	}, nil
+}
+
+// NewPrivateKey creates an RS256 or EdDSA key from a PEM encoded PKCS #8 (or PKCS #1 for RSA) private key
+func NewPrivateKey(id, algorithm string, pemData []byte) (Key, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s is not PEM encoded", id)
+	}
+
+	var privateKey crypto.PrivateKey
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if rsaErr != nil {
+			return Key{}, errors.Wrapf(err, "key %s is not a valid private key", id)
+		}
+		privateKey = rsaKey
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgorithmRS256 {
+			return Key{}, fmt.Errorf("key %s is an RSA key, which can't be used for %s", id, algorithm)
+		}
+		return Key{ID: id, Algorithm: algorithm, signingKey: key, verifyingKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		if algorithm != AlgorithmEdDSA {
+			return Key{}, fmt.Errorf("key %s is an Ed25519 key, which can't be used for %s", id, algorithm)
+		}
+		return Key{ID: id, Algorithm: algorithm, signingKey: key, verifyingKey: key.Public()}, nil
+	default:
+		return Key{}, fmt.Errorf("key %s has an unsupported type", id)
+	}
+}
+
+// NewPublicKey creates an RS256 or EdDSA key from a PEM encoded PKIX public key. It can only verify tokens.
+func NewPublicKey(id, algorithm string, pemData []byte) (Key, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s is not PEM encoded", id)
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return Key{}, errors.Wrapf(err, "key %s is not a valid public key", id)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm != AlgorithmRS256 {
+			return Key{}, fmt.Errorf("key %s is an RSA key, which can't be used for %s", id, algorithm)
+		}
+	case ed25519.PublicKey:
+		if algorithm != AlgorithmEdDSA {
+			return Key{}, fmt.Errorf("key %s is an Ed25519 key, which can't be used for %s", id, algorithm)
+		}
+	default:
+		return Key{}, fmt.Errorf("key %s has an unsupported type", id)
+	}
+
+	return Key{ID: id, Algorithm: algorithm, verifyingKey: publicKey}, nil
+}
+
+// CanSign reports whether the key has its private part
+func (k Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+func (k Key) signingMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
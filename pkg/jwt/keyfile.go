@@ -0,0 +1,85 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// KeyFile lists the keys of the provider. Only the active key signs new tokens; the others are
+// kept so tokens they signed stay valid until they expire.
+//
+//	{
+//	  "activeKeyId": "2024-04",
+//	  "keys": [
+//	    {"kid": "2024-04", "alg": "EdDSA", "privateKeyFile": "2024-04.pem"},
+//	    {"kid": "2024-01", "alg": "RS256", "publicKeyFile": "2024-01.pub.pem"}
+//	  ]
+//	}
+//
+// Key file paths are relative to the key file. HS256 keys set their base64 encoded "secret" instead.
+type KeyFile struct {
+	ActiveKeyID string         `json:"activeKeyId"`
+	Keys        []KeyFileEntry `json:"keys"`
+}
+
+type KeyFileEntry struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"privateKeyFile"`
+	PublicKeyFile  string `json:"publicKeyFile"`
+}
+
+// LoadKeyFile reads the keys listed in the file, and returns them with the active key ID
+func LoadKeyFile(path string) (string, []Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "os.ReadFile error")
+	}
+
+	var keyFile KeyFile
+	if err := json.Unmarshal(data, &keyFile); err != nil {
+		return "", nil, errors.Wrap(err, "json.Unmarshal error")
+	}
+
+	dir := filepath.Dir(path)
+	keys := []Key{}
+	for _, entry := range keyFile.Keys {
+		key, err := loadKeyFileEntry(dir, entry)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keyFile.ActiveKeyID, keys, nil
+}
+
+func loadKeyFileEntry(dir string, entry KeyFileEntry) (Key, error) {
+	if entry.ID == "" {
+		return Key{}, errors.New("key has no kid")
+	}
+
+	switch {
+	case entry.Algorithm == AlgorithmHS256:
+		return NewHMACKey(entry.ID, entry.Secret)
+	case entry.PrivateKeyFile != "":
+		pemData, err := os.ReadFile(filepath.Join(dir, entry.PrivateKeyFile))
+		if err != nil {
+			return Key{}, errors.Wrap(err, "os.ReadFile error")
+		}
+		return NewPrivateKey(entry.ID, entry.Algorithm, pemData)
+	case entry.PublicKeyFile != "":
+		pemData, err := os.ReadFile(filepath.Join(dir, entry.PublicKeyFile))
+		if err != nil {
+			return Key{}, errors.Wrap(err, "os.ReadFile error")
+		}
+		return NewPublicKey(entry.ID, entry.Algorithm, pemData)
+	default:
+		return Key{}, fmt.Errorf("key %s has no key material", entry.ID)
+	}
+}
@@ -0,0 +1,29 @@
+package jwt
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+)
+
+// RequireRoles only lets through the logged in users having one of the roles.
+// It must run after the JWT middleware.
+func RequireRoles(roles ...string) fiber.Handler {
+	allowed := map[string]bool{}
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		claims, err := GetLoggedInUser(c)
+		if err != nil || !allowed[claims.Role] {
+			return fiber.ErrForbidden
+		}
+
+		return c.Next()
+	}
+}
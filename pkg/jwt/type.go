@@ -4,12 +4,19 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JWTUser struct {
 	UserID string `json:"userId"`
 	Name   string `json:"name"`
 	Email  string `json:"email"`
+	Role   string `json:"role"`
+
+	// SessionID is the login session the token is issued for, so it can be revoked together with it
+	SessionID string `json:"sid"`
+	// TokenID is unique for every issued token
+	TokenID string `json:"jti"`
 }
 
 func BuildJWTClaims(user JWTUser, expireDuration time.Duration) jwt.MapClaims {
@@ -17,6 +24,9 @@ func BuildJWTClaims(user JWTUser, expireDuration time.Duration) jwt.MapClaims {
 		"userId": user.UserID,
 		"name":   user.Name,
 		"email":  user.Email,
+		"role":   user.Role,
+		"sid":    user.SessionID,
+		"jti":    uuid.NewString(),
 		"exp":    jwt.NewNumericDate(time.Now().Add(expireDuration)),
 	}
 }
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	ErrInvalidIdempotencyKey    = fiber.NewError(http.StatusBadRequest, "invalid idempotency key")
+	ErrIdempotencyKeyReused     = fiber.NewError(http.StatusUnprocessableEntity, "idempotency key already used for a different request")
+	ErrIdempotencyKeyInProgress = fiber.NewError(http.StatusConflict, "request with the same idempotency key is still being processed")
+)
+
+type IdempotencyRecord struct {
+	Scope        string         `db:"scope"`
+	Key          string         `db:"idempotency_key"`
+	RequestHash  string         `db:"request_hash"`
+	StatusCode   sql.NullInt32  `db:"status_code"`
+	ContentType  sql.NullString `db:"content_type"`
+	ResponseBody []byte         `db:"response_body"`
+}
+
+// IdempotencyStore persists the responses of requests sent with an idempotency key
+type IdempotencyStore interface {
+	// Reserve saves a new in-progress record which expires after ttl.
+	// It returns false if an unexpired record with the same scope & key already exists.
+	Reserve(ctx context.Context, record IdempotencyRecord, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, scope, key string) (IdempotencyRecord, error)
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	Release(ctx context.Context, scope, key string) error
+}
+
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+
+	// Scope returns the namespace the keys are unique in, e.g. the logged in user ID
+	Scope func(c *fiber.Ctx) (string, error)
+
+	// TTL is how long a stored response can be replayed
+	TTL time.Duration
+}
+
+// Idempotency replays the stored response of a request sent with the same Idempotency-Key header,
+// instead of handling it again. Requests without the header are handled as usual.
+func Idempotency(cfg IdempotencyConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return ErrInvalidIdempotencyKey
+		}
+
+		scope, err := cfg.Scope(c)
+		if err != nil {
+			return fiber.ErrForbidden
+		}
+
+		ctx := c.Context()
+		record := IdempotencyRecord{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: hashRequest(c),
+		}
+
+		reserved, err := cfg.Store.Reserve(ctx, record, cfg.TTL)
+		if err != nil {
+			return errors.Wrap(err, "Reserve idempotency key error")
+		}
+		if !reserved {
+			return replayResponse(c, cfg.Store, record)
+		}
+
+		completed := false
+		defer func() {
+			// let the request be retried if it did not produce a response worth replaying
+			if !completed {
+				_ = cfg.Store.Release(ctx, scope, key)
+			}
+		}()
+
+		err = c.Next()
+		if err != nil {
+			// a rejected request is rendered here, so its response can be replayed like a successful one
+			if err := c.App().Config().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		statusCode := c.Response().StatusCode()
+		if statusCode >= fiber.StatusInternalServerError {
+			return nil
+		}
+
+		record.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+		record.ContentType = sql.NullString{String: string(c.Response().Header.ContentType()), Valid: true}
+		record.ResponseBody = append([]byte{}, c.Response().Body()...)
+
+		err = cfg.Store.Complete(ctx, record)
+		if err != nil {
+			return errors.Wrap(err, "Complete idempotency key error")
+		}
+		completed = true
+
+		return nil
+	}
+}
+
+func replayResponse(c *fiber.Ctx, store IdempotencyStore, record IdempotencyRecord) error {
+	existing, err := store.Get(c.Context(), record.Scope, record.Key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// the other request was released in the meantime
+			return ErrIdempotencyKeyInProgress
+		}
+
+		return errors.Wrap(err, "Get idempotency key error")
+	}
+
+	if existing.RequestHash != record.RequestHash {
+		return ErrIdempotencyKeyReused
+	}
+	if !existing.StatusCode.Valid {
+		return ErrIdempotencyKeyInProgress
+	}
+
+	c.Set(IdempotencyReplayedHeader, "true")
+	c.Set(fiber.HeaderContentType, existing.ContentType.String)
+	return c.Status(int(existing.StatusCode.Int32)).Send(existing.ResponseBody)
+}
+
+func hashRequest(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.OriginalURL()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+
+	return hex.EncodeToString(h.Sum(nil))
+}
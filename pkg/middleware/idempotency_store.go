@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PostgresIdempotencyStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresIdempotencyStore(db *sqlx.DB) PostgresIdempotencyStore {
+	return PostgresIdempotencyStore{db: db}
+}
+
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, record IdempotencyRecord, ttl time.Duration) (bool, error) {
+	// an expired record is taken over as if it never existed
+	query := `
+		INSERT INTO idempotency_keys
+			(scope, idempotency_key, request_hash, expires_at)
+		VALUES
+			($1, $2, $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (scope, idempotency_key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			status_code = NULL,
+			content_type = NULL,
+			response_body = NULL,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE
+			idempotency_keys.expires_at < NOW()
+	`
+
+	res, err := s.db.ExecContext(ctx, query, record.Scope, record.Key, record.RequestHash, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (s *PostgresIdempotencyStore) Get(ctx context.Context, scope, key string) (IdempotencyRecord, error) {
+	var result IdempotencyRecord
+
+	query := `
+		SELECT
+			scope,
+			idempotency_key,
+			request_hash,
+			status_code,
+			content_type,
+			response_body
+		FROM
+			idempotency_keys
+		WHERE
+			scope = $1
+			AND idempotency_key = $2
+		LIMIT 1
+	`
+
+	err := s.db.GetContext(ctx, &result, query, scope, key)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, record IdempotencyRecord) error {
+	query := `
+		UPDATE
+			idempotency_keys
+		SET
+			status_code = $3,
+			content_type = $4,
+			response_body = $5
+		WHERE
+			scope = $1
+			AND idempotency_key = $2
+	`
+
+	_, err := s.db.ExecContext(ctx, query, record.Scope, record.Key, record.StatusCode, record.ContentType, record.ResponseBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	query := `
+		DELETE FROM
+			idempotency_keys
+		WHERE
+			scope = $1
+			AND idempotency_key = $2
+			AND status_code IS NULL
+	`
+
+	_, err := s.db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresIdempotencyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM
+			idempotency_keys
+		WHERE
+			expires_at < NOW()
+	`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// StartCleanup periodically deletes the expired idempotency keys until ctx is cancelled
+func (s *PostgresIdempotencyStore) StartCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := s.DeleteExpired(ctx)
+				if err != nil {
+					log.Println("failed to delete expired idempotency keys: ", err)
+					continue
+				}
+				if deleted > 0 {
+					log.Printf("deleted %d expired idempotency keys", deleted)
+				}
+			}
+		}
+	}()
+}
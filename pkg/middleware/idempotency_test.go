@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// memoryIdempotencyStore keeps the records in memory. It never expires them.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, record IdempotencyRecord, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[record.Scope+"/"+record.Key]; ok {
+		return false, nil
+	}
+
+	s.records[record.Scope+"/"+record.Key] = record
+	return true, nil
+}
+
+func (s *memoryIdempotencyStore) Get(ctx context.Context, scope, key string) (IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[scope+"/"+key]
+	if !ok {
+		return record, sql.ErrNoRows
+	}
+
+	return record, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.Scope+"/"+record.Key] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, scope+"/"+key)
+	return nil
+}
+
+// TestIdempotencyReplaysClientErrors stores a rejected request like a successful one,
+// so retrying it with the same key replays the rejection instead of handling it again.
+func TestIdempotencyReplaysClientErrors(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			code := fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				code = e.Code
+			}
+
+			return c.Status(code).SendString(err.Error())
+		},
+	})
+
+	calls := 0
+	app.Post("/", Idempotency(IdempotencyConfig{
+		Store: newMemoryIdempotencyStore(),
+		Scope: func(c *fiber.Ctx) (string, error) { return "user", nil },
+		TTL:   time.Hour,
+	}), func(c *fiber.Ctx) error {
+		calls++
+		return fiber.NewError(fiber.StatusBadRequest, "insufficient balance")
+	})
+
+	for i, replayed := range []string{"", "true"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount":"10"}`))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != fiber.StatusBadRequest || string(body) != "insufficient balance" {
+			t.Errorf("request %d = %d %q, want 400 %q", i, resp.StatusCode, body, "insufficient balance")
+		}
+		if got := resp.Header.Get(IdempotencyReplayedHeader); got != replayed {
+			t.Errorf("request %d %s = %q, want %q", i, IdempotencyReplayedHeader, got, replayed)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+// TestIdempotencyReleasesServerErrors lets a request which failed on the server be retried with the same key
+func TestIdempotencyReleasesServerErrors(t *testing.T) {
+	app := fiber.New()
+
+	calls := 0
+	app.Post("/", Idempotency(IdempotencyConfig{
+		Store: newMemoryIdempotencyStore(),
+		Scope: func(c *fiber.Ctx) (string, error) { return "user", nil },
+		TTL:   time.Hour,
+	}), func(c *fiber.Ctx) error {
+		calls++
+		return fiber.ErrServiceUnavailable
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if resp.StatusCode != fiber.StatusServiceUnavailable {
+			t.Errorf("request %d = %d, want %d", i, resp.StatusCode, fiber.StatusServiceUnavailable)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
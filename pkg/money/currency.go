@@ -0,0 +1,28 @@
+package money
+
+import "strings"
+
+// DefaultExponent is the number of minor unit digits of most ISO 4217 currencies
+const DefaultExponent = 2
+
+// exponents lists the ISO 4217 currencies whose minor unit digits differ from DefaultExponent
+var exponents = map[string]int{
+	// no minor unit
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+
+	// thousandths
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+
+	// ten-thousandths
+	"CLF": 4, "UYW": 4,
+}
+
+// Exponent returns the number of minor unit digits of the currency, e.g. 2 for USD and 0 for JPY
+func Exponent(currency string) int {
+	if exponent, ok := exponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+
+	return DefaultExponent
+}
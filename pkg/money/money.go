@@ -0,0 +1,131 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidAmount   = errors.New("amount is not a valid number")
+	ErrTooManyDecimals = errors.New("amount has more decimal places than the currency allows")
+	ErrAmountTooLarge  = errors.New("amount is too large")
+)
+
+// decimalPattern is the only way an amount can be written: digits, optionally followed by a point and more digits.
+// Signs, exponents and the other forms big.Rat would accept, like "0x10" or "1/3", are refused.
+var decimalPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// Money is an amount in the minor unit of its currency, e.g. 1234 USD is $12.34
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{
+		Amount:   amount,
+		Currency: strings.ToUpper(currency),
+	}
+}
+
+// Parse reads a decimal amount written in the major unit of the currency, e.g. "12.34" USD
+func Parse(value, currency string) (Money, error) {
+	if !decimalPattern.MatchString(value) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+
+	minor := rat.Mul(rat, new(big.Rat).SetInt(pow10(Exponent(currency))))
+	if !minor.IsInt() {
+		return Money{}, ErrTooManyDecimals
+	}
+	if !minor.Num().IsInt64() {
+		return Money{}, ErrAmountTooLarge
+	}
+
+	return New(minor.Num().Int64(), currency), nil
+}
+
+func (m Money) Exponent() int {
+	return Exponent(m.Currency)
+}
+
+// Decimal returns the amount in the major unit of the currency
+func (m Money) Decimal() Decimal {
+	return Decimal(m.String())
+}
+
+// String writes the amount in the major unit of the currency, with all of its minor unit digits
+func (m Money) String() string {
+	exponent := m.Exponent()
+	if exponent == 0 {
+		return big.NewInt(m.Amount).String()
+	}
+
+	return new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(exponent)).FloatString(exponent)
+}
+
+// Convert exchanges the money into another currency, where rate is the price of one major unit
+// of m's currency in the other currency. The result is rounded down to the other currency's minor unit.
+func (m Money) Convert(currency string, rate *big.Rat) (Money, error) {
+	converted := new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(m.Exponent()))
+	converted.Mul(converted, rate)
+	converted.Mul(converted, new(big.Rat).SetInt(pow10(Exponent(currency))))
+
+	amount := new(big.Int).Quo(converted.Num(), converted.Denom())
+	if !amount.IsInt64() {
+		return Money{}, ErrAmountTooLarge
+	}
+
+	return New(amount.Int64(), currency), nil
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+// Decimal is an amount in the major unit of a currency, as written in a request or a response.
+// It's kept verbatim since the currency, and so its precision, is only known after the whole payload is read.
+type Decimal string
+
+// Money converts the decimal into the minor unit of the currency
+func (d Decimal) Money(currency string) (Money, error) {
+	return Parse(string(d), currency)
+}
+
+// UnmarshalJSON accepts both JSON numbers and numeric strings
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*d = ""
+		return nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return ErrInvalidAmount
+	}
+	if !decimalPattern.MatchString(number.String()) {
+		return ErrInvalidAmount
+	}
+
+	*d = Decimal(number)
+	return nil
+}
+
+// MarshalJSON writes the decimal as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("0"), nil
+	}
+
+	return []byte(d), nil
+}
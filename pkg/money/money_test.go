@@ -0,0 +1,174 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+	}{
+		{"JPY", 0},
+		{"USD", 2},
+		{"KWD", 3},
+		{"usd", 2},
+		{"jpy", 0},
+		// currencies which aren't listed have the default exponent
+		{"IDR", DefaultExponent},
+	}
+
+	for _, tt := range tests {
+		if got := Exponent(tt.currency); got != tt.want {
+			t.Errorf("Exponent(%q) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"12.34", "USD", 1234, nil},
+		{"12", "USD", 1200, nil},
+		{"0.5", "USD", 50, nil},
+		{"0", "USD", 0, nil},
+		{"007.10", "USD", 710, nil},
+		{"1500", "JPY", 1500, nil},
+		{"1.234", "KWD", 1234, nil},
+		{"1.5", "JPY", 0, ErrTooManyDecimals},
+		{"12.345", "USD", 0, ErrTooManyDecimals},
+		{"1.2345", "KWD", 0, ErrTooManyDecimals},
+		{"12.340", "USD", 1234, nil},
+
+		// only plain decimals are accepted
+		{"", "USD", 0, ErrInvalidAmount},
+		{"-1", "USD", 0, ErrInvalidAmount},
+		{"+1", "USD", 0, ErrInvalidAmount},
+		{"0x10", "USD", 0, ErrInvalidAmount},
+		{"0b101", "USD", 0, ErrInvalidAmount},
+		{"0o17", "USD", 0, ErrInvalidAmount},
+		{"1_000", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"1/3", "USD", 0, ErrInvalidAmount},
+		{".5", "USD", 0, ErrInvalidAmount},
+		{"5.", "USD", 0, ErrInvalidAmount},
+		{" 5", "USD", 0, ErrInvalidAmount},
+		{"1,000", "USD", 0, ErrInvalidAmount},
+		{"NaN", "USD", 0, ErrInvalidAmount},
+
+		// the largest amount which fits, and the smallest which doesn't
+		{"92233720368547758.07", "USD", math.MaxInt64, nil},
+		{"92233720368547758.08", "USD", 0, ErrAmountTooLarge},
+		{"9223372036854775807", "JPY", math.MaxInt64, nil},
+		{"9223372036854775808", "JPY", 0, ErrAmountTooLarge},
+		{"100000000000000000000000000", "KWD", 0, ErrAmountTooLarge},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value, tt.currency)
+		if err != tt.err {
+			t.Errorf("Parse(%q, %s) error = %v, want %v", tt.value, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && (got.Amount != tt.want || got.Currency != tt.currency) {
+			t.Errorf("Parse(%q, %s) = %d %s, want %d %s", tt.value, tt.currency, got.Amount, got.Currency, tt.want, tt.currency)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1234, "USD"), "12.34"},
+		{New(5, "USD"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-1234, "USD"), "-12.34"},
+		{New(1500, "JPY"), "1500"},
+		{New(1234, "KWD"), "1.234"},
+		{New(1, "KWD"), "0.001"},
+		{New(math.MaxInt64, "USD"), "92233720368547758.07"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%d %s String() = %q, want %q", tt.money.Amount, tt.money.Currency, got, tt.want)
+		}
+	}
+}
+
+// TestParseString reads back every amount it writes
+func TestParseString(t *testing.T) {
+	for _, m := range []Money{New(1234, "USD"), New(1500, "JPY"), New(1, "KWD"), New(math.MaxInt64, "USD")} {
+		got, err := Parse(m.String(), m.Currency)
+		if err != nil || got != m {
+			t.Errorf("Parse(%q, %s) = %v, %v, want %v", m.String(), m.Currency, got, err, m)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		money    Money
+		currency string
+		rate     string
+		want     int64
+		err      error
+	}{
+		// 10.00 USD at 150.5 JPY per USD
+		{New(1000, "USD"), "JPY", "150.5", 1505, nil},
+		// rounded down to the minor unit of the other currency
+		{New(1, "USD"), "JPY", "150.5", 1, nil},
+		{New(1000, "JPY"), "USD", "0.0066", 660, nil},
+		{New(1000, "USD"), "KWD", "0.30712", 3071, nil},
+		{New(math.MaxInt64, "USD"), "JPY", "150", 0, ErrAmountTooLarge},
+	}
+
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		got, err := tt.money.Convert(tt.currency, rate)
+		if err != tt.err {
+			t.Errorf("Convert %d %s to %s error = %v, want %v", tt.money.Amount, tt.money.Currency, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && (got.Amount != tt.want || got.Currency != tt.currency) {
+			t.Errorf("Convert %d %s to %s = %d %s, want %d %s", tt.money.Amount, tt.money.Currency, tt.currency, got.Amount, got.Currency, tt.want, tt.currency)
+		}
+	}
+}
+
+func TestDecimalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json  string
+		want  Decimal
+		valid bool
+	}{
+		{`12.34`, "12.34", true},
+		{`"12.34"`, "12.34", true},
+		{`null`, "", true},
+		{`-1`, "", false},
+		{`1e3`, "", false},
+		{`"0x10"`, "", false},
+		{`"abc"`, "", false},
+	}
+
+	for _, tt := range tests {
+		var got Decimal
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err == nil) != tt.valid {
+			t.Errorf("Unmarshal(%s) error = %v, want valid %v", tt.json, err, tt.valid)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("Unmarshal(%s) = %q, want %q", tt.json, got, tt.want)
+		}
+	}
+}
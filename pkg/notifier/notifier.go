@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is sent to a single recipient, e.g. by email
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// Notifier delivers messages to the users
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// LogNotifier writes the messages to the log. It's meant for local development.
+type LogNotifier struct{}
+
+func NewLogNotifier() LogNotifier {
+	return LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, message Message) error {
+	log.Printf("notification to %s: [%s] %s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileNotifier appends the messages to a file as JSON lines. It's meant for local development and tests.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, message Message) error {
+	if message.SentAt.IsZero() {
+		message.SentAt = time.Now()
+	}
+
+	line, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal error")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile error")
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "file.Write error")
+	}
+
+	return nil
+}
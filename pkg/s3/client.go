@@ -3,20 +3,21 @@ package s3
 import (
 	"context"
 	"fmt"
-	"mime/multipart"
+	"io"
+	"time"
 
+	"github.com/ahmadnaufal/openidea-paimonbank/pkg/storage"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
 type S3Provider struct {
-	client *s3.Client
-	bucket string
-	region string
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+	region        string
 }
 
 func NewS3Provider(cfg aws.Config, bucket, region, id, secret string) S3Provider {
@@ -28,30 +29,41 @@ func NewS3Provider(cfg aws.Config, bucket, region, id, secret string) S3Provider
 	})
 
 	return S3Provider{
-		client: client,
-		bucket: bucket,
-		region: region,
+		client:        client,
+		presignClient: s3.NewPresignClient(client),
+		bucket:        bucket,
+		region:        region,
 	}
 }
 
-func (s *S3Provider) UploadImage(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
-	file, err := fileHeader.Open()
+func (s *S3Provider) Put(ctx context.Context, key string, body io.Reader, contentType string) (storage.Object, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	_, err := s.client.PutObject(ctx, input)
 	if err != nil {
-		return "", errors.Wrap(err, "fileHeader.Open() error")
+		return storage.Object{}, errors.Wrap(err, "s3Client.PutObject error")
 	}
-	defer file.Close()
 
-	filename := fmt.Sprintf("%s.jpg", uuid.NewString())
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+	finalUrl := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, key)
+	return storage.Object{Key: key, URL: finalUrl}, nil
+}
+
+// SignedURL presigns a GET request of the object, which is valid for ttl
+func (s *S3Provider) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filename),
-		ACL:    types.ObjectCannedACLPublicRead,
-		Body:   file,
-	})
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
 	if err != nil {
-		return "", errors.Wrap(err, "s3Client.PutObject error")
+		return "", errors.Wrap(err, "s3Client.PresignGetObject error")
 	}
 
-	finalUrl := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, filename)
-	return finalUrl, nil
+	return req.URL, nil
 }
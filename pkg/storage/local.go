@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// LocalRoutePrefix is the route the local store serves its files from
+const LocalRoutePrefix = "/uploads"
+
+// LocalStore keeps the objects on the local disk. It's meant for local development.
+type LocalStore struct {
+	dir        string
+	baseURL    string
+	signingKey []byte
+}
+
+// NewLocalStore creates the directory if it doesn't exist yet. baseURL is the address the app is reachable at,
+// and signingKey signs the download URLs. Without a signing key, a random one is used, so the URLs
+// are only valid until the app restarts.
+func NewLocalStore(dir, baseURL, signingKey string) (LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return LocalStore{}, errors.Wrap(err, "os.MkdirAll error")
+	}
+
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return LocalStore{}, errors.Wrap(err, "rand.Read error")
+		}
+	}
+
+	return LocalStore{
+		dir:        dir,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		signingKey: key,
+	}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) (Object, error) {
+	if err := validateKey(key); err != nil {
+		return Object{}, err
+	}
+
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Object{}, errors.Wrap(err, "os.MkdirAll error")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return Object{}, errors.Wrap(err, "os.Create error")
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		return Object{}, errors.Wrap(err, "io.Copy error")
+	}
+
+	return Object{
+		Key: key,
+		URL: s.objectURL(key),
+	}, nil
+}
+
+// SignedURL gives the object URL with an HMAC signature of the key and its expiry
+func (s *LocalStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(ttl).Unix()
+	return fmt.Sprintf("%s?expires=%d&signature=%s", s.objectURL(key), expires, s.sign(key, expires)), nil
+}
+
+// RegisterRoute serves the stored files, as long as the request is signed and not expired yet
+func (s *LocalStore) RegisterRoute(r *fiber.App) {
+	r.Get(LocalRoutePrefix+"/*", s.serveObject)
+}
+
+func (s *LocalStore) serveObject(c *fiber.Ctx) error {
+	key := c.Params("*")
+	if err := validateKey(key); err != nil {
+		return fiber.ErrNotFound
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return fiber.ErrForbidden
+	}
+
+	if !hmac.Equal([]byte(c.Query("signature")), []byte(s.sign(key, expires))) {
+		return fiber.ErrForbidden
+	}
+
+	return c.SendFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s *LocalStore) objectURL(key string) string {
+	return fmt.Sprintf("%s%s/%s", s.baseURL, LocalRoutePrefix, key)
+}
+
+func (s *LocalStore) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%s:%d", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryStore keeps the objects in memory. It's meant for tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, body io.Reader, contentType string) (Object, error) {
+	if err := validateKey(key); err != nil {
+		return Object{}, err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Object{}, errors.Wrap(err, "io.ReadAll error")
+	}
+
+	s.mu.Lock()
+	s.objects[key] = data
+	s.mu.Unlock()
+
+	return Object{Key: key, URL: "memory://" + key}, nil
+}
+
+func (s *MemoryStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("memory://%s?expires=%d", key, time.Now().Add(ttl).Unix()), nil
+}
+
+// Get returns the stored object, and whether it exists
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.objects[key]
+	return data, ok
+}
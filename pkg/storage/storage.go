@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Object is where an uploaded object is stored. The URL identifies the object, but it isn't
+// publicly readable; downloading it requires a signed URL.
+type Object struct {
+	Key string
+	URL string
+}
+
+// ObjectStore stores uploaded files privately, and gives short-lived URLs to download them
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (Object, error)
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// validateKey makes sure the key is a plain object name, so it can't escape the store
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "..") {
+		return ErrInvalidKey
+	}
+
+	return nil
+}